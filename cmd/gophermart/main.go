@@ -14,6 +14,8 @@ import (
 	"github.com/ddyachkov/gophermart/internal/config"
 	"github.com/ddyachkov/gophermart/internal/handler"
 	"github.com/ddyachkov/gophermart/internal/queue"
+	"github.com/ddyachkov/gophermart/internal/scheduler"
+	"github.com/ddyachkov/gophermart/internal/server"
 	"github.com/ddyachkov/gophermart/internal/storage"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -31,36 +33,177 @@ func main() {
 	}
 	defer dbpool.Close()
 
+	withdrawalPolicy := storage.WithdrawalPolicy{
+		Scope:    cfg.WithdrawalPolicy,
+		OrderCap: cfg.WithdrawalOrderCap,
+	}
+	expirationPolicy := storage.ExpirationPolicy{
+		Months: cfg.PointsTTLMonths,
+		Notice: cfg.PointsExpiryNotice,
+	}
+	tiers, err := storage.ParseTiers(cfg.Tiers)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	tierPolicy := storage.TierPolicy{
+		Basis: cfg.TierBasis,
+		Tiers: tiers,
+	}
+	referralPolicy := storage.ReferralPolicy{
+		ReferrerBonus: cfg.ReferrerBonus,
+		RefereeBonus:  cfg.RefereeBonus,
+		MonthlyLimit:  cfg.ReferralMonthlyLimit,
+		MinAccrual:    cfg.ReferralMinAccrual,
+	}
+	transferPolicy := storage.TransferPolicy{
+		DailySum:   cfg.TransferDailySum,
+		DailyCount: cfg.TransferDailyCount,
+	}
+	lockoutPolicy := storage.LockoutPolicy{
+		MaxFailures:   cfg.LoginMaxFailures,
+		IPMaxFailures: cfg.LoginIPMaxFailures,
+		Delay:         cfg.LoginFailureDelay,
+		Lockout:       cfg.LoginLockout,
+		Window:        cfg.LoginFailureWindow,
+	}
+
 	storage, err := storage.NewDBStorage(dbCtx, dbpool)
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
+	if err = storage.SetWithdrawalPolicy(withdrawalPolicy); err != nil {
+		log.Fatalln(err.Error())
+	}
+	if err = storage.SetClawbackPolicy(cfg.ClawbackPolicy); err != nil {
+		log.Fatalln(err.Error())
+	}
+	if err = storage.SetExpirationPolicy(dbCtx, expirationPolicy); err != nil {
+		log.Fatalln(err.Error())
+	}
+	if err = storage.SetTierPolicy(tierPolicy); err != nil {
+		log.Fatalln(err.Error())
+	}
+	storage.SetReferralPolicy(referralPolicy)
+	storage.SetTransferPolicy(transferPolicy)
+	storage.SetLockoutPolicy(lockoutPolicy)
 
 	accrualler := accrual.NewAccrualService(cfg.AccrualSystemAddress)
 	queue := queue.NewQueue(accrualler, storage)
-	server := http.Server{
-		Addr:    cfg.RunAddress,
-		Handler: handler.NewHandler(storage, queue),
+	srv := server.NewServer(cfg, handler.NewHandler(storage, queue, cfg))
+
+	scheduler := scheduler.NewScheduler()
+	scheduler.Every("release expired holds", cfg.HoldSweepInterval, func(ctx context.Context) error {
+		released, err := storage.ReleaseExpiredHolds(ctx)
+		if released > 0 {
+			log.Println("released expired holds:", released)
+		}
+		return err
+	})
+	scheduler.Every("expire points", cfg.PointsExpiryInterval, func(ctx context.Context) error {
+		expired, err := storage.ExpirePoints(ctx)
+		if expired > 0 {
+			log.Println("expired point lots:", expired)
+		}
+		return err
+	})
+	scheduler.Every("recalculate tiers", cfg.TierRecalcInterval, func(ctx context.Context) error {
+		updated, err := storage.RecalculateTiers(ctx)
+		if updated > 0 {
+			log.Println("users moved to another tier:", updated)
+		}
+		return err
+	})
+	scheduler.Every("delete old user events", cfg.EventPruneInterval, func(ctx context.Context) error {
+		deleted, err := storage.DeleteUserEvents(ctx, cfg.EventRetention)
+		if deleted > 0 {
+			log.Println("deleted user events:", deleted)
+		}
+		return err
+	})
+
+	scheduler.Every("delete expired idempotency keys", cfg.IdempotencySweepPeriod, func(ctx context.Context) error {
+		deleted, err := storage.DeleteExpiredIdempotencyKeys(ctx)
+		if deleted > 0 {
+			log.Println("deleted expired idempotency keys:", deleted)
+		}
+		return err
+	})
+	scheduler.Every("delete expired login failures", cfg.LoginSweepInterval, func(ctx context.Context) error {
+		deleted, err := storage.DeleteExpiredLoginFailures(ctx)
+		if deleted > 0 {
+			log.Println("deleted expired login failures:", deleted)
+		}
+		return err
+	})
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+
+	var redirect *http.Server
+	if cfg.TLSEnabled() {
+		reloader, err := server.NewCertReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		reloader.OnReload(func() {
+			if err := storage.RecordConfigReload(watchCtx, "tls_cert", cfg.TLSCert); err != nil {
+				log.Println("audit:", err.Error())
+			}
+		})
+		go reloader.Watch(watchCtx, cfg.TLSReloadInterval)
+
+		srv.TLSConfig, err = server.NewTLSConfig(reloader, cfg.TLSClientCA)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if cfg.TLSClientCA != "" && len(cfg.TLSClientAllow) == 0 {
+			log.Println("TLS_CLIENT_ALLOW is empty, client certificates are not accepted for the admin API")
+		}
+
+		if cfg.RedirectAddress != "" {
+			redirect = server.NewRedirectServer(cfg)
+		}
 	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 	go queue.Start()
+	scheduler.Start()
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.TLSEnabled() {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalln(err)
 		}
 	}()
 
+	if redirect != nil {
+		go func() {
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalln(err)
+			}
+		}()
+	}
+
 	<-quit
 
 	queue.Stop()
+	scheduler.Stop()
 
 	srvCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := server.Shutdown(srvCtx); err != nil {
+	if redirect != nil {
+		if err := redirect.Shutdown(srvCtx); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := srv.Shutdown(srvCtx); err != nil {
 		log.Fatalln(err)
 	}
 }
@@ -0,0 +1,125 @@
+// Package events fans the user event notifications of the database out to the
+// streams open on this replica.
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultRetryDelay = time.Second
+
+// Listener delivers the ids of the users having new events, see
+// storage.DBStorage.ListenUserEvents.
+type Listener interface {
+	ListenUserEvents(ctx context.Context, notify func(userID int)) error
+}
+
+// Broker listens only while somebody is subscribed, so that an idle replica
+// does not hold a database connection.
+type Broker struct {
+	listener   Listener
+	retryDelay time.Duration
+
+	mu          sync.Mutex
+	subscribers map[int]map[chan struct{}]struct{}
+	count       int
+	cancel      context.CancelFunc
+}
+
+func NewBroker(l Listener) *Broker {
+	return &Broker{
+		listener:    l,
+		retryDelay:  defaultRetryDelay,
+		subscribers: make(map[int]map[chan struct{}]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving a value when the user may have new
+// events. Wake ups are coalesced, so the subscriber has to fetch everything
+// after the last event it has seen.
+func (b *Broker) Subscribe(userID int) (wake <-chan struct{}, unsubscribe func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	b.count++
+	if b.count == 1 {
+		ctx, cancel := context.WithCancel(context.Background())
+		b.cancel = cancel
+		go b.listen(ctx)
+	}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() { b.unsubscribe(userID, ch) })
+	}
+}
+
+func (b *Broker) unsubscribe(userID int, ch chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers[userID], ch)
+	if len(b.subscribers[userID]) == 0 {
+		delete(b.subscribers, userID)
+	}
+	b.count--
+	if b.count == 0 {
+		b.cancel()
+		b.cancel = nil
+	}
+}
+
+func (b *Broker) listen(ctx context.Context) {
+	for {
+		err := b.listener.ListenUserEvents(ctx, b.notify)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("user events:", err)
+		// Notifications sent while reconnecting are lost, everybody has to
+		// check for new events.
+		b.notifyAll()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(b.retryDelay):
+		}
+	}
+}
+
+func (b *Broker) notify(userID int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[userID] {
+		wake(ch)
+	}
+}
+
+func (b *Broker) notifyAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			wake(ch)
+		}
+	}
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
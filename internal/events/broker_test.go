@@ -0,0 +1,85 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeListener struct {
+	notifications chan int
+	fail          chan struct{}
+	listening     int32
+	connects      int32
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{
+		notifications: make(chan int),
+		fail:          make(chan struct{}),
+	}
+}
+
+func (l *fakeListener) ListenUserEvents(ctx context.Context, notify func(userID int)) error {
+	atomic.AddInt32(&l.connects, 1)
+	atomic.AddInt32(&l.listening, 1)
+	defer atomic.AddInt32(&l.listening, -1)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.fail:
+			return errors.New("connection lost")
+		case userID := <-l.notifications:
+			notify(userID)
+		}
+	}
+}
+
+func received(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+func TestBroker(t *testing.T) {
+	listener := newFakeListener()
+	broker := NewBroker(listener)
+	broker.retryDelay = 10 * time.Millisecond
+
+	first, unsubscribeFirst := broker.Subscribe(1)
+	second, unsubscribeSecond := broker.Subscribe(2)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&listener.listening) == 1
+	}, time.Second, time.Millisecond, "the first subscriber starts listening")
+
+	listener.notifications <- 1
+	listener.notifications <- 1
+	assert.True(t, received(first))
+	assert.False(t, received(first), "wake ups are coalesced")
+	assert.False(t, received(second), "other users are not woken up")
+
+	listener.fail <- struct{}{}
+	assert.True(t, received(first), "everybody is woken up after a reconnect")
+	assert.True(t, received(second), "everybody is woken up after a reconnect")
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&listener.connects) == 2
+	}, time.Second, time.Millisecond)
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&listener.listening))
+	unsubscribeSecond()
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&listener.listening) == 0
+	}, time.Second, time.Millisecond, "the last subscriber stops listening")
+	assert.Empty(t, broker.subscribers)
+}
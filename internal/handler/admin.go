@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ddyachkov/gophermart/internal/problem"
+	"github.com/ddyachkov/gophermart/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// targetUser exposes the user from the path as "userID", so that the user
+// scoped handlers can serve the admin routes as well.
+func (h handler) targetUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		badRequest(c, "wrong user id")
+		return
+	}
+
+	if _, err := h.storage.GetUserRole(c, userID); err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.Set("userID", userID)
+	c.Next()
+}
+
+func (h handler) SearchUsers(c *gin.Context) {
+	users, err := h.storage.SearchUsers(c, c.Query("login"))
+	if err != nil {
+		problem.Internal(c, err)
+		return
+	}
+	if len(users) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func (h handler) GetUser(c *gin.Context) {
+	user, err := h.storage.GetUser(c, c.MustGet("userID").(int))
+	if err != nil {
+		problem.Internal(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (h handler) SetUserRole(c *gin.Context) {
+	var request struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	if err := h.storage.SetUserRole(c, c.MustGet("userID").(int), request.Role); err != nil {
+		fail(c, err)
+		return
+	}
+
+	message := gin.H{
+		"message": "role updated",
+		"status":  http.StatusOK,
+	}
+	c.JSON(http.StatusOK, message)
+}
+
+func (h handler) AdjustUserBalance(c *gin.Context) {
+	var request struct {
+		Sum    float32 `json:"sum"`
+		Reason string  `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.Sum == 0 || strings.TrimSpace(request.Reason) == "" {
+		badRequest(c, "non-zero sum and reason are required")
+		return
+	}
+
+	adjustment, err := h.storage.AdjustUserBalance(c, c.MustGet("userID").(int), request.Sum, request.Reason, c.GetString("operator"))
+	if errors.Is(err, storage.ErrInsufficientFunds) {
+		// An adjustment is not a payment, the conflict is with the balance.
+		problem.Abort(c, http.StatusConflict, apiErrors[storage.ErrInsufficientFunds].code, err.Error())
+		return
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, adjustment)
+}
+
+func (h handler) GetAuditEvents(c *gin.Context) {
+	filter := storage.AuditFilter{Type: c.Query("type")}
+	page, err := parsePage(c)
+	if err == nil {
+		filter.Page = page
+		filter.From, err = parseTime(c, "from")
+	}
+	if err == nil {
+		filter.To, err = parseTime(c, "to")
+	}
+	if err == nil && c.Query("user_id") != "" {
+		var userID int
+		userID, err = strconv.Atoi(c.Query("user_id"))
+		filter.UserID = &userID
+	}
+	if err != nil {
+		badRequest(c, errWrongQueryParams.Error())
+		return
+	}
+
+	events, next, err := h.storage.GetAuditEvents(c, filter)
+	if errors.Is(err, storage.ErrNoAuditEventsFound) {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	setNextPageHeaders(c, next)
+	c.JSON(http.StatusOK, events)
+}
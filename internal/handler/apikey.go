@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ddyachkov/gophermart/internal/problem"
+	"github.com/gin-gonic/gin"
+)
+
+func (h handler) CreateAPIKey(c *gin.Context) {
+	var request struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	key, err := h.storage.CreateAPIKey(c, c.MustGet("userID").(int), request.Name, request.Scopes)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+func (h handler) GetAPIKeys(c *gin.Context) {
+	keys, err := h.storage.GetAPIKeys(c, c.MustGet("userID").(int))
+	if err != nil {
+		problem.Internal(c, err)
+		return
+	}
+	if len(keys) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+func (h handler) RevokeAPIKey(c *gin.Context) {
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		badRequest(c, "wrong api key id")
+		return
+	}
+
+	if err := h.storage.RevokeAPIKey(c, c.MustGet("userID").(int), keyID); err != nil {
+		fail(c, err)
+		return
+	}
+
+	message := gin.H{
+		"message": "api key revoked",
+		"status":  http.StatusOK,
+	}
+	c.JSON(http.StatusOK, message)
+}
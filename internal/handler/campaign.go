@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ddyachkov/gophermart/internal/problem"
+	"github.com/ddyachkov/gophermart/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type campaignRequest struct {
+	Name           string    `json:"name"`
+	Kind           string    `json:"kind"`
+	Value          float32   `json:"value"`
+	StartsAt       time.Time `json:"starts_at"`
+	EndsAt         time.Time `json:"ends_at"`
+	FirstOrderOnly bool      `json:"first_order_only"`
+	MinAccrual     float32   `json:"min_accrual"`
+	Budget         *float32  `json:"budget"`
+}
+
+func (r campaignRequest) campaign(id int) storage.Campaign {
+	return storage.Campaign{
+		ID:             id,
+		Name:           r.Name,
+		Kind:           r.Kind,
+		Value:          r.Value,
+		StartsAt:       r.StartsAt,
+		EndsAt:         r.EndsAt,
+		FirstOrderOnly: r.FirstOrderOnly,
+		MinAccrual:     r.MinAccrual,
+		Budget:         r.Budget,
+	}
+}
+
+func campaignID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		badRequest(c, "wrong campaign id")
+		return 0, false
+	}
+
+	return id, true
+}
+
+func (h handler) CreateCampaign(c *gin.Context) {
+	var request campaignRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	campaign, err := h.storage.CreateCampaign(c, request.campaign(0))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, campaign)
+}
+
+func (h handler) GetCampaigns(c *gin.Context) {
+	campaigns, err := h.storage.GetCampaigns(c)
+	if err != nil {
+		problem.Internal(c, err)
+		return
+	}
+	if len(campaigns) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaigns)
+}
+
+func (h handler) GetCampaign(c *gin.Context) {
+	id, ok := campaignID(c)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.storage.GetCampaign(c, id)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+func (h handler) UpdateCampaign(c *gin.Context) {
+	id, ok := campaignID(c)
+	if !ok {
+		return
+	}
+
+	var request campaignRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	campaign, err := h.storage.UpdateCampaign(c, request.campaign(id))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+func (h handler) DeleteCampaign(c *gin.Context) {
+	id, ok := campaignID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.storage.DeleteCampaign(c, id); err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ddyachkov/gophermart/internal/problem"
+	"github.com/ddyachkov/gophermart/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Codes of the errors detected by the handlers themselves.
+const (
+	codeInvalidOrderNumber = "invalid_order_number"
+	codeWrongPassword      = "wrong_current_password"
+	codeInsufficientRole   = "insufficient_role"
+	codeAPIKeyScope        = "api_key_scope_missing"
+	codeLoginLocked        = "login_locked"
+)
+
+type apiError struct {
+	status int
+	code   string
+}
+
+// apiErrors maps storage errors to responses. The codes are part of the API
+// and must not change once released.
+var apiErrors = map[error]apiError{
+	storage.ErrLoginUniqueViolation:       {http.StatusConflict, "login_taken"},
+	storage.ErrIncorrectUserCredentials:   {http.StatusUnauthorized, "invalid_credentials"},
+	storage.ErrUnknownReferralCode:        {http.StatusBadRequest, "unknown_referral_code"},
+	storage.ErrInvalidResetToken:          {http.StatusBadRequest, "invalid_reset_token"},
+	storage.ErrUserNotFound:               {http.StatusNotFound, "user_not_found"},
+	storage.ErrUnknownRole:                {http.StatusBadRequest, "unknown_role"},
+	storage.ErrInvalidAPIKey:              {http.StatusUnauthorized, "invalid_api_key"},
+	storage.ErrAPIKeyNotFound:             {http.StatusNotFound, "api_key_not_found"},
+	storage.ErrWrongAPIKeyScopes:          {http.StatusBadRequest, "invalid_api_key_settings"},
+	storage.ErrInvalidCursor:              {http.StatusBadRequest, "invalid_cursor"},
+	storage.ErrHaveOrderByDiffUser:        {http.StatusConflict, "order_uploaded_by_other_user"},
+	storage.ErrOrderNotFound:              {http.StatusNotFound, "order_not_found"},
+	storage.ErrOrderOwnedByDiffUser:       {http.StatusForbidden, "order_owned_by_other_user"},
+	storage.ErrOrderNotProcessed:          {http.StatusConflict, "order_not_processed"},
+	storage.ErrInsufficientFunds:          {http.StatusPaymentRequired, "insufficient_funds"},
+	storage.ErrWrongSum:                   {http.StatusBadRequest, "invalid_sum"},
+	storage.ErrWithdrawalAlreadyExists:    {http.StatusConflict, "withdrawal_exists"},
+	storage.ErrWithdrawalCapExceeded:      {http.StatusConflict, "withdrawal_cap_exceeded"},
+	storage.ErrWithdrawalNotFound:         {http.StatusNotFound, "withdrawal_not_found"},
+	storage.ErrWithdrawalNotReversible:    {http.StatusConflict, "withdrawal_not_reversible"},
+	storage.ErrClawbackExceedsAccrual:     {http.StatusConflict, "clawback_exceeds_accrual"},
+	storage.ErrHoldNotFound:               {http.StatusNotFound, "hold_not_found"},
+	storage.ErrHoldNotActive:              {http.StatusConflict, "hold_not_active"},
+	storage.ErrIdempotencyKeyReused:       {http.StatusUnprocessableEntity, "idempotency_key_reused"},
+	storage.ErrIdempotencyKeyInProgress:   {http.StatusConflict, "idempotency_key_in_progress"},
+	storage.ErrTiersDisabled:              {http.StatusNotFound, "tiers_disabled"},
+	storage.ErrRecipientNotFound:          {http.StatusNotFound, "recipient_not_found"},
+	storage.ErrTransferToSelf:             {http.StatusBadRequest, "transfer_to_self"},
+	storage.ErrTransferDailyLimitExceeded: {http.StatusConflict, "transfer_limit_exceeded"},
+	storage.ErrCampaignNotFound:           {http.StatusNotFound, "campaign_not_found"},
+	storage.ErrCampaignInUse:              {http.StatusConflict, "campaign_in_use"},
+	storage.ErrWrongCampaignSettings:      {http.StatusBadRequest, "invalid_campaign_settings"},
+	errWrongQueryParams:                   {http.StatusBadRequest, "invalid_query"},
+}
+
+// fail responds with the problem mapped from err. Unknown errors are logged
+// and reported as internal without details.
+func fail(c *gin.Context, err error) {
+	for target, apiErr := range apiErrors {
+		if errors.Is(err, target) {
+			problem.Abort(c, apiErr.status, apiErr.code, err.Error())
+			return
+		}
+	}
+	problem.Internal(c, err)
+}
+
+func badRequest(c *gin.Context, detail string) {
+	problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidRequest, detail)
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ddyachkov/gophermart/internal/problem"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	eventBatchSize = 100
+	eventKeepAlive = 15 * time.Second
+	// eventRetry is the reconnection delay suggested to the clients.
+	eventRetry = 3 * time.Second
+)
+
+// GetUserEvents streams the user events as Server-Sent Events. A client
+// reconnecting with Last-Event-ID gets the events it has missed, a new one
+// only gets the events happening after it has connected.
+func (h handler) GetUserEvents(c *gin.Context) {
+	userID := c.MustGet("userID").(int)
+
+	// Subscribing before reading the position, so that events committed in
+	// between wake the stream up.
+	wake, unsubscribe := h.broker.Subscribe(userID)
+	defer unsubscribe()
+
+	var lastID int64
+	var err error
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		lastID, err = strconv.ParseInt(header, 10, 64)
+		if err != nil || lastID < 0 {
+			badRequest(c, "wrong Last-Event-ID")
+			return
+		}
+	} else {
+		lastID, err = h.storage.GetLastUserEventID(c, userID)
+		if err != nil {
+			problem.Internal(c, err)
+			return
+		}
+	}
+
+	// The stream outlives the server write timeout, which only makes sense
+	// for regular responses.
+	err = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		problem.Internal(c, err)
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if _, err := fmt.Fprintf(c.Writer, "retry: %d\n\n", eventRetry.Milliseconds()); err != nil {
+		return
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		batch, err := h.storage.GetUserEvents(c, userID, lastID, eventBatchSize)
+		if err != nil {
+			if c.Request.Context().Err() == nil {
+				log.Printf("request %s: user events: %s", c.GetString("requestID"), err.Error())
+			}
+			return
+		}
+		for _, event := range batch {
+			if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data); err != nil {
+				return
+			}
+			lastID = event.ID
+		}
+		c.Writer.Flush()
+		if len(batch) == eventBatchSize {
+			continue
+		}
+
+		// Events are also checked on keep-alive, in case a notification got
+		// lost while the listener was connecting.
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-wake:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
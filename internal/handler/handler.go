@@ -1,50 +1,144 @@
 package handler
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/ddyachkov/gophermart/internal/config"
+	"github.com/ddyachkov/gophermart/internal/events"
 	"github.com/ddyachkov/gophermart/internal/middleware"
+	"github.com/ddyachkov/gophermart/internal/notifier"
+	"github.com/ddyachkov/gophermart/internal/openapi"
+	"github.com/ddyachkov/gophermart/internal/problem"
 	"github.com/ddyachkov/gophermart/internal/queue"
 	"github.com/ddyachkov/gophermart/internal/storage"
+	"github.com/ddyachkov/gophermart/internal/validation"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type handler struct {
-	storage *storage.DBStorage
-	queue   *queue.Queue
+	storage  *storage.DBStorage
+	queue    *queue.Queue
+	cfg      *config.ServerConfig
+	notifier notifier.Notifier
+	resets   *middleware.RateLimiter
+	broker   *events.Broker
+	spec     *openapi.Document
+}
+
+const maxBatchSize = 1000
+
+type batchOrderResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+	Status int    `json:"status"`
 }
 
 type user struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login        string `json:"login"`
+	Password     string `json:"password"`
+	ReferralCode string `json:"referral_code,omitempty"`
 }
 
-func NewHandler(s *storage.DBStorage, q *queue.Queue) http.Handler {
+func NewHandler(s *storage.DBStorage, q *queue.Queue, cfg *config.ServerConfig) http.Handler {
 	router := gin.Default()
 
 	h := handler{
-		storage: s,
-		queue:   q,
+		storage:  s,
+		queue:    q,
+		cfg:      cfg,
+		notifier: notifier.New(cfg.PasswordResetFile),
+		resets:   middleware.NewRateLimiter(cfg.PasswordResetLimit),
+		broker:   events.NewBroker(s),
+		spec:     openapi.MustLoad(),
+	}
+
+	validate := h.spec.Validator()
+
+	router.ContextWithFallback = true
+	// Without trusted proxies the client IP is the peer address, otherwise
+	// anybody could pick an IP with X-Forwarded-For and evade the limits.
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalln(err.Error())
+	}
+	router.Use(middleware.RequestID(), h.AuditContext, middleware.Decompress(), gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{"/api/user/events"})))
+	router.NoRoute(func(c *gin.Context) {
+		problem.Abort(c, http.StatusNotFound, problem.CodeNotFound, "route not found")
+	})
+	router.GET("/api/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, gin.MIMEJSON, openapi.Spec())
+	})
+
+	public := router.Group("/")
+	public.Use(middleware.RateLimit(middleware.NewRateLimiter(cfg.RateLimitPublic)), validate)
+	{
+		public.POST("/api/user/register", h.RegisterUser)
+		public.POST("/api/user/login", h.LogInUser)
+		public.POST("/api/user/password/reset", h.RequestPasswordReset)
+		public.POST("/api/user/password/reset/confirm", h.ConfirmPasswordReset)
 	}
 
-	router.Use(middleware.Decompress(), gzip.Gzip(gzip.DefaultCompression))
-	router.POST("/api/user/register", h.RegisterUser)
-	router.POST("/api/user/login", h.LogInUser)
+	ipLimit := middleware.RateLimitByIP(middleware.NewRateLimiter(cfg.RateLimitIP))
 
 	authorized := router.Group("/")
-	authorized.Use(h.Authenticate)
+	authorized.Use(ipLimit, h.Authenticate, middleware.RateLimit(middleware.NewRateLimiter(cfg.RateLimitUser)), validate)
 	{
-		authorized.POST("/api/user/orders", h.PostUserOrder)
+		authorized.POST("/api/user/orders", h.Idempotent, h.PostUserOrder)
+		authorized.POST("/api/user/orders/batch", h.Idempotent, h.PostUserOrders)
 		authorized.GET("/api/user/orders", h.GetUserOrders)
+		authorized.GET("/api/user/orders/:number", h.GetUserOrder)
 		authorized.GET("/api/user/balance", h.GetUserBalance)
-		authorized.POST("/api/user/balance/withdraw", h.WithdrawFromUserBalance)
+		authorized.PUT("/api/user/password", h.ChangePassword)
+		authorized.POST("/api/user/keys", h.CreateAPIKey)
+		authorized.GET("/api/user/keys", h.GetAPIKeys)
+		authorized.DELETE("/api/user/keys/:id", h.RevokeAPIKey)
+		authorized.GET("/api/user/tier", h.GetUserTier)
+		authorized.GET("/api/user/referrals", h.GetUserReferrals)
+		authorized.POST("/api/user/balance/transfer", h.Idempotent, h.TransferPoints)
+		authorized.GET("/api/user/transfers", h.GetUserTransfers)
+		authorized.POST("/api/user/balance/withdraw", h.Idempotent, h.WithdrawFromUserBalance)
+		authorized.POST("/api/user/balance/holds", h.Idempotent, h.CreateHold)
+		authorized.POST("/api/user/balance/holds/:id/capture", h.CaptureHold)
+		authorized.POST("/api/user/balance/holds/:id/release", h.ReleaseHold)
 		authorized.GET("/api/user/withdrawals", h.GetUserWithdrawals)
+		authorized.GET("/api/user/events", h.GetUserEvents)
+	}
+
+	adminLimit := middleware.RateLimit(middleware.NewRateLimiter(cfg.RateLimitAdmin))
+
+	support := router.Group("/api/admin")
+	support.Use(ipLimit, h.Authorize(storage.RoleSupport, storage.RoleAdmin), adminLimit, validate)
+	{
+		support.GET("/users", h.SearchUsers)
+		support.GET("/users/:id", h.targetUser, h.GetUser)
+		support.GET("/users/:id/balance", h.targetUser, h.GetUserBalance)
+		support.GET("/users/:id/orders", h.targetUser, h.GetUserOrders)
+		support.GET("/users/:id/withdrawals", h.targetUser, h.GetUserWithdrawals)
+		support.GET("/campaigns", h.GetCampaigns)
+		support.GET("/campaigns/:id", h.GetCampaign)
+	}
+
+	admin := router.Group("/api/admin")
+	admin.Use(ipLimit, h.Authorize(storage.RoleAdmin), adminLimit, validate)
+	{
+		admin.GET("/audit", h.GetAuditEvents)
+		admin.PUT("/users/:id/role", h.targetUser, h.SetUserRole)
+		admin.POST("/users/:id/balance/adjustments", h.targetUser, h.AdjustUserBalance)
+		admin.POST("/withdrawals/:id/reverse", h.ReverseWithdrawal)
+		admin.POST("/orders/:number/clawback", h.ClawbackOrderAccrual)
+		admin.POST("/campaigns", h.CreateCampaign)
+		admin.PUT("/campaigns/:id", h.UpdateCampaign)
+		admin.DELETE("/campaigns/:id", h.DeleteCampaign)
 	}
 
 	return router
@@ -53,34 +147,31 @@ func NewHandler(s *storage.DBStorage, q *queue.Queue) http.Handler {
 func (h handler) RegisterUser(c *gin.Context) {
 	var u user
 	if err := c.ShouldBindJSON(&u); err != nil {
-		message := gin.H{
-			"message": "wrong request format",
-			"status":  http.StatusBadRequest,
-		}
-		c.JSON(http.StatusBadRequest, message)
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	violations := validation.ValidateLogin(u.Login)
+	violations = append(violations, h.passwordPolicy().Validate(u.Password, u.Login)...)
+	if len(violations) > 0 {
+		problem.Validation(c, "validation failed", violations)
 		return
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
 	if err != nil {
-		message := gin.H{
-			"message": err.Error(),
-			"status":  http.StatusInternalServerError,
-		}
-		c.JSON(http.StatusInternalServerError, message)
+		problem.Internal(c, err)
+		return
 	}
 
-	if err := h.storage.CreateUser(c, u.Login, string(hashedPassword)); err != nil {
-		httpStatusCode := http.StatusInternalServerError
-		if errors.Is(err, storage.ErrLoginUniqueViolation) {
-			httpStatusCode = http.StatusConflict
-		}
-
-		message := gin.H{
-			"message": err.Error(),
-			"status":  httpStatusCode,
-		}
-		c.JSON(httpStatusCode, message)
+	storage.AuditMetaFromContext(c).Actor = u.Login
+	if u.ReferralCode != "" {
+		err = h.storage.CreateReferredUser(c, u.Login, string(hashedPassword), u.ReferralCode)
+	} else {
+		err = h.storage.CreateUser(c, u.Login, string(hashedPassword))
+	}
+	if err != nil {
+		fail(c, err)
 		return
 	}
 
@@ -93,40 +184,42 @@ func (h handler) RegisterUser(c *gin.Context) {
 	c.JSON(http.StatusOK, message)
 }
 
+func (h handler) passwordPolicy() validation.PasswordPolicy {
+	return validation.PasswordPolicy{
+		MinLength:      h.cfg.PasswordMinLength,
+		RequireClasses: h.cfg.PasswordRequireClasses,
+	}
+}
+
 func (h handler) LogInUser(c *gin.Context) {
 	var u user
 	if err := c.ShouldBindJSON(&u); err != nil {
-		message := gin.H{
-			"message": "user successfully registered and authenticated",
-			"status":  http.StatusBadRequest,
-		}
-		c.JSON(http.StatusBadRequest, message)
+		badRequest(c, "wrong request format")
 		return
 	}
 
-	_, hashedPassword, err := h.storage.GetUserCredentials(c, u.Login)
+	failures, locked := h.rejectLockedLogin(c, u.Login)
+	if locked {
+		return
+	}
+
+	userID, hashedPassword, err := h.storage.GetUserCredentials(c, u.Login)
 	if err != nil {
-		httpStatusCode := http.StatusInternalServerError
 		if errors.Is(err, storage.ErrIncorrectUserCredentials) {
-			httpStatusCode = http.StatusUnauthorized
+			h.loginFailed(c, u.Login, nil)
 		}
-		message := gin.H{
-			"message": err.Error(),
-			"status":  httpStatusCode,
-		}
-		c.JSON(httpStatusCode, message)
+		fail(c, err)
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(u.Password)); err != nil {
-		message := gin.H{
-			"message": storage.ErrIncorrectUserCredentials.Error(),
-			"status":  http.StatusUnauthorized,
-		}
-		c.JSON(http.StatusUnauthorized, message)
+		h.loginFailed(c, u.Login, &userID)
+		fail(c, storage.ErrIncorrectUserCredentials)
 		return
 	}
 
+	h.loginSucceeded(c, u.Login, userID, failures)
+
 	authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(u.Login+":"+u.Password))
 	c.Header("Authorization", authorization)
 	message := gin.H{
@@ -139,39 +232,28 @@ func (h handler) LogInUser(c *gin.Context) {
 func (h handler) PostUserOrder(c *gin.Context) {
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
-		message := gin.H{
-			"message": err.Error(),
-			"status":  http.StatusBadRequest,
-		}
-		c.JSON(http.StatusBadRequest, message)
+		badRequest(c, "request body can not be read")
 		return
 	}
 
 	orderNumber := string(body)
 	if err = goluhn.Validate(orderNumber); err != nil {
-		message := gin.H{
-			"message": "wrong order number format",
-			"status":  http.StatusUnprocessableEntity,
-		}
-		c.JSON(http.StatusUnprocessableEntity, message)
+		problem.Abort(c, http.StatusUnprocessableEntity, codeInvalidOrderNumber, "wrong order number format")
 		return
 	}
 
 	userID := c.MustGet("userID").(int)
 	err = h.storage.InsertNewOrder(c, orderNumber, userID)
-	if err != nil {
-		httpStatusCode := http.StatusInternalServerError
-		switch err {
-		case storage.ErrHaveOrderBySameUser:
-			httpStatusCode = http.StatusOK
-		case storage.ErrHaveOrderByDiffUser:
-			httpStatusCode = http.StatusConflict
-		}
+	if err == storage.ErrHaveOrderBySameUser {
 		message := gin.H{
 			"message": err.Error(),
-			"status":  httpStatusCode,
+			"status":  http.StatusOK,
 		}
-		c.JSON(httpStatusCode, message)
+		c.JSON(http.StatusOK, message)
+		return
+	}
+	if err != nil {
+		fail(c, err)
 		return
 	}
 
@@ -191,74 +273,173 @@ func (h handler) PostUserOrder(c *gin.Context) {
 	c.JSON(http.StatusAccepted, message)
 }
 
-func (h handler) GetUserOrders(c *gin.Context) {
+func (h handler) PostUserOrders(c *gin.Context) {
+	var orderNumbers []string
+	if strings.Contains(c.ContentType(), "json") {
+		if err := c.ShouldBindJSON(&orderNumbers); err != nil {
+			badRequest(c, "wrong request format")
+			return
+		}
+	} else {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			badRequest(c, "request body can not be read")
+			return
+		}
+		for _, line := range strings.Split(string(body), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				orderNumbers = append(orderNumbers, line)
+			}
+		}
+	}
+
+	if len(orderNumbers) == 0 || len(orderNumbers) > maxBatchSize {
+		badRequest(c, "batch must contain from 1 to "+strconv.Itoa(maxBatchSize)+" order numbers")
+		return
+	}
+
+	results := make([]batchOrderResult, len(orderNumbers))
+	validNumbers := make([]string, 0, len(orderNumbers))
+	validIndexes := make([]int, 0, len(orderNumbers))
+	for i, orderNumber := range orderNumbers {
+		results[i] = batchOrderResult{Number: orderNumber}
+		if err := goluhn.Validate(orderNumber); err != nil {
+			results[i].Result = "invalid format"
+			results[i].Status = http.StatusUnprocessableEntity
+			continue
+		}
+		validNumbers = append(validNumbers, orderNumber)
+		validIndexes = append(validIndexes, i)
+	}
+
 	userID := c.MustGet("userID").(int)
-	orders, err := h.storage.GetUserOrders(c, userID)
-	if err != nil {
-		httpStatusCode := http.StatusInternalServerError
-		if errors.Is(err, storage.ErrNoOrdersFound) {
-			httpStatusCode = http.StatusNoContent
+	var accepted []storage.Order
+	if len(validNumbers) > 0 {
+		insertResults, err := h.storage.InsertNewOrders(c, validNumbers, userID)
+		if err != nil {
+			problem.Internal(c, err)
+			return
 		}
-		message := gin.H{
-			"message": err.Error(),
-			"status":  httpStatusCode,
+
+		for j, insertErr := range insertResults {
+			result := &results[validIndexes[j]]
+			switch insertErr {
+			case nil:
+				result.Result = "accepted"
+				result.Status = http.StatusAccepted
+				accepted = append(accepted, storage.Order{Number: result.Number, Status: "NEW", UserID: userID})
+			case storage.ErrHaveOrderBySameUser:
+				result.Result = "already uploaded by you"
+				result.Status = http.StatusOK
+			case storage.ErrHaveOrderByDiffUser:
+				result.Result = "conflict"
+				result.Status = http.StatusConflict
+			}
 		}
-		c.JSON(httpStatusCode, message)
+	}
+
+	if len(accepted) > 0 {
+		go func() {
+			for _, order := range accepted {
+				h.queue.Push(order)
+			}
+		}()
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+func (h handler) GetUserOrders(c *gin.Context) {
+	page, err := parsePage(c)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	filter := storage.OrderFilter{
+		Page:     page,
+		Statuses: parseList(c, "status"),
+	}
+	if filter.UploadedFrom, err = parseTime(c, "uploaded_from"); err == nil {
+		filter.UploadedTo, err = parseTime(c, "uploaded_to")
+	}
+	if err != nil {
+		fail(c, err)
 		return
 	}
 
+	userID := c.MustGet("userID").(int)
+	orders, next, err := h.storage.GetUserOrders(c, userID, filter)
+	if errors.Is(err, storage.ErrNoOrdersFound) {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	setNextPageHeaders(c, next)
 	c.JSON(http.StatusOK, orders)
 }
 
+func (h handler) GetUserOrder(c *gin.Context) {
+	userID := c.MustGet("userID").(int)
+	order, err := h.storage.GetUserOrder(c, c.Param("number"), userID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
 func (h handler) GetUserBalance(c *gin.Context) {
 	userID := c.MustGet("userID").(int)
-	current, withdrawn, err := h.storage.GetUserBalance(c, userID)
+	balance, err := h.storage.GetUserBalance(c, userID)
 	if err != nil {
-		message := gin.H{
-			"message": err.Error(),
-			"status":  http.StatusInternalServerError,
-		}
-		c.JSON(http.StatusInternalServerError, message)
+		problem.Internal(c, err)
 		return
 	}
-	message := gin.H{
-		"current":   current,
-		"withdrawn": withdrawn,
+	c.JSON(http.StatusOK, balance)
+}
+
+func (h handler) GetUserTier(c *gin.Context) {
+	userID := c.MustGet("userID").(int)
+	progress, err := h.storage.GetUserTier(c, userID)
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	c.JSON(http.StatusOK, message)
+
+	c.JSON(http.StatusOK, progress)
+}
+
+func (h handler) GetUserReferrals(c *gin.Context) {
+	userID := c.MustGet("userID").(int)
+	referrals, err := h.storage.GetUserReferrals(c, userID)
+	if err != nil {
+		problem.Internal(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, referrals)
 }
 
 func (h handler) WithdrawFromUserBalance(c *gin.Context) {
 	w := storage.Withdrawal{}
-	if err := c.ShouldBindJSON(&w); err != nil {
-		message := gin.H{
-			"message": "wrong request format",
-			"status":  http.StatusBadRequest,
-		}
-		c.JSON(http.StatusBadRequest, message)
+	if err := c.ShouldBindJSON(&w); err != nil || w.Sum <= 0 {
+		badRequest(c, "wrong request format")
 		return
 	}
 
 	if err := goluhn.Validate(w.OrderNumber); err != nil {
-		message := gin.H{
-			"message": "wrong order number format",
-			"status":  http.StatusUnprocessableEntity,
-		}
-		c.JSON(http.StatusUnprocessableEntity, message)
+		problem.Abort(c, http.StatusUnprocessableEntity, codeInvalidOrderNumber, "wrong order number format")
 		return
 	}
 
 	userID := c.MustGet("userID").(int)
 	if err := h.storage.WithdrawFromUserBalance(c, w.OrderNumber, w.Sum, userID); err != nil {
-		httpStatusCode := http.StatusInternalServerError
-		if errors.Is(err, storage.ErrInsufficientFunds) {
-			httpStatusCode = http.StatusPaymentRequired
-		}
-		message := gin.H{
-			"message": err.Error(),
-			"status":  httpStatusCode,
-		}
-		c.JSON(httpStatusCode, message)
+		fail(c, err)
 		return
 	}
 
@@ -269,21 +450,199 @@ func (h handler) WithdrawFromUserBalance(c *gin.Context) {
 	c.JSON(http.StatusOK, message)
 }
 
+func (h handler) CreateHold(c *gin.Context) {
+	var hold struct {
+		OrderNumber string  `json:"order"`
+		Sum         float32 `json:"sum"`
+		ExpiresIn   int     `json:"expires_in"`
+	}
+	if err := c.ShouldBindJSON(&hold); err != nil || hold.Sum <= 0 || hold.ExpiresIn < 0 {
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	if err := goluhn.Validate(hold.OrderNumber); err != nil {
+		problem.Abort(c, http.StatusUnprocessableEntity, codeInvalidOrderNumber, "wrong order number format")
+		return
+	}
+
+	ttl := h.cfg.HoldTTL
+	if hold.ExpiresIn > 0 {
+		ttl = time.Duration(hold.ExpiresIn) * time.Second
+	}
+	if ttl > h.cfg.HoldMaxTTL {
+		ttl = h.cfg.HoldMaxTTL
+	}
+
+	userID := c.MustGet("userID").(int)
+	created, err := h.storage.CreateHold(c, hold.OrderNumber, hold.Sum, ttl, userID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h handler) CaptureHold(c *gin.Context) {
+	h.resolveHold(c, h.storage.CaptureHold)
+}
+
+func (h handler) ReleaseHold(c *gin.Context) {
+	h.resolveHold(c, h.storage.ReleaseHold)
+}
+
+func (h handler) resolveHold(c *gin.Context, resolve func(context.Context, int, int) (storage.Hold, error)) {
+	holdID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		badRequest(c, "wrong hold id")
+		return
+	}
+
+	userID := c.MustGet("userID").(int)
+	hold, err := resolve(c, holdID, userID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hold)
+}
+
+func (h handler) TransferPoints(c *gin.Context) {
+	var transfer struct {
+		ToLogin string  `json:"to_login"`
+		Sum     float32 `json:"sum"`
+	}
+	if err := c.ShouldBindJSON(&transfer); err != nil || transfer.ToLogin == "" || transfer.Sum <= 0 {
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	userID := c.MustGet("userID").(int)
+	result, err := h.storage.TransferPoints(c, transfer.ToLogin, transfer.Sum, userID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h handler) GetUserTransfers(c *gin.Context) {
+	page, err := parsePage(c)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	userID := c.MustGet("userID").(int)
+	transfers, next, err := h.storage.GetUserTransfers(c, userID, page)
+	if errors.Is(err, storage.ErrNoTransfersFound) {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	setNextPageHeaders(c, next)
+	c.JSON(http.StatusOK, transfers)
+}
+
 func (h handler) GetUserWithdrawals(c *gin.Context) {
+	filter := storage.WithdrawalFilter{}
+	page, err := parsePage(c)
+	if err == nil {
+		filter.Page = page
+		filter.ProcessedFrom, err = parseTime(c, "processed_from")
+	}
+	if err == nil {
+		filter.ProcessedTo, err = parseTime(c, "processed_to")
+	}
+	if err == nil {
+		filter.MinSum, err = parseSum(c, "min_sum")
+	}
+	if err == nil {
+		filter.MaxSum, err = parseSum(c, "max_sum")
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
 	userID := c.MustGet("userID").(int)
-	withdrawals, err := h.storage.GetUserWithdrawals(c, userID)
+	withdrawals, next, err := h.storage.GetUserWithdrawals(c, userID, filter)
+	if errors.Is(err, storage.ErrNoWithdrawalsFound) {
+		c.Status(http.StatusNoContent)
+		return
+	}
 	if err != nil {
-		httpStatusCode := http.StatusInternalServerError
-		if errors.Is(err, storage.ErrNoWithdrawalsFound) {
-			httpStatusCode = http.StatusNoContent
-		}
-		message := gin.H{
-			"message": err.Error(),
-			"status":  httpStatusCode,
-		}
-		c.JSON(httpStatusCode, message)
+		fail(c, err)
+		return
+	}
+
+	setNextPageHeaders(c, next)
+	if c.Query("summary") != "true" {
+		c.JSON(http.StatusOK, withdrawals)
+		return
+	}
+
+	summary, err := h.storage.GetUserWithdrawalsSummary(c, userID, filter)
+	if err != nil {
+		problem.Internal(c, err)
+		return
+	}
+	response := gin.H{
+		"withdrawals": withdrawals,
+		"summary":     summary,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h handler) ReverseWithdrawal(c *gin.Context) {
+	withdrawalID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		badRequest(c, "wrong withdrawal id")
+		return
+	}
+
+	var reversal struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&reversal); err != nil || strings.TrimSpace(reversal.Reason) == "" {
+		badRequest(c, "reversal reason is required")
+		return
+	}
+
+	if err := h.storage.ReverseWithdrawal(c, withdrawalID, reversal.Reason); err != nil {
+		fail(c, err)
+		return
+	}
+
+	message := gin.H{
+		"message": "withdrawal reversed",
+		"status":  http.StatusOK,
+	}
+	c.JSON(http.StatusOK, message)
+}
+
+func (h handler) ClawbackOrderAccrual(c *gin.Context) {
+	var clawback struct {
+		Sum    float32 `json:"sum"`
+		Reason string  `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&clawback); err != nil || clawback.Sum < 0 || strings.TrimSpace(clawback.Reason) == "" {
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	result, err := h.storage.ClawbackOrderAccrual(c, c.Param("number"), clawback.Sum, clawback.Reason)
+	if err != nil {
+		fail(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, withdrawals)
+	c.JSON(http.StatusOK, result)
 }
@@ -1,47 +1,266 @@
 package handler
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"io"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/ddyachkov/gophermart/internal/middleware"
+	"github.com/ddyachkov/gophermart/internal/problem"
 	"github.com/ddyachkov/gophermart/internal/storage"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// AuditContext attaches the request origin to the context used by storage
+// calls, so that audit events can be attributed.
+func (h handler) AuditContext(c *gin.Context) {
+	meta := &storage.AuditMeta{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	c.Request = c.Request.WithContext(storage.WithAuditMeta(c.Request.Context(), meta))
+	c.Next()
+}
+
 func (h handler) Authenticate(c *gin.Context) {
-	login, password, ok := c.Request.BasicAuth()
+	var userID int
+	var ok bool
+	if token := apiKeyToken(c); token != "" {
+		userID, ok = h.authenticateAPIKey(c, token)
+	} else {
+		userID, _, ok = h.authenticate(c)
+	}
 	if !ok {
-		message := gin.H{
-			"message": storage.ErrIncorrectUserCredentials.Error(),
-			"status":  http.StatusUnauthorized,
-		}
-		c.AbortWithStatusJSON(http.StatusUnauthorized, message)
 		return
 	}
+	c.Set("userID", userID)
+	c.Next()
+}
+
+// apiKeyToken returns the key passed either as a bearer token or in the
+// X-API-Key header.
+func apiKeyToken(c *gin.Context) string {
+	if token := c.GetHeader("X-API-Key"); token != "" {
+		return token
+	}
+	authorization := c.GetHeader("Authorization")
+	if len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(authorization[len("Bearer "):])
+	}
+	return ""
+}
+
+func (h handler) authenticateAPIKey(c *gin.Context, token string) (userID int, ok bool) {
+	userID, login, key, err := h.storage.AuthenticateAPIKey(c, token)
+	if err != nil {
+		fail(c, err)
+		return 0, false
+	}
+	storage.AuditMetaFromContext(c).Actor = login + " (api key " + key.Prefix + ")"
+
+	// Routes are available to API keys when the specification declares the
+	// scope they require, everything else needs the user password.
+	operation := h.spec.Operation(c.Request.Method, c.FullPath())
+	if operation == nil || operation.APIKeyScope == "" || !key.HasScope(operation.APIKeyScope) {
+		problem.Abort(c, http.StatusForbidden, codeAPIKeyScope, "api key is not allowed to access this resource")
+		return 0, false
+	}
+
+	return userID, true
+}
+
+// Authorize lets through holders of a verified client certificate and users
+// having one of the given roles.
+func (h handler) Authorize(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// A certificate signed by the client CA is not enough, its subject has
+		// to be allowed as well, otherwise the request needs credentials.
+		if cert := middleware.ClientCertificate(c); cert != nil && middleware.ClientCertificateAllowed(cert, h.cfg.TLSClientAllow) {
+			c.Set("operator", "cert:"+cert.Subject.CommonName)
+			storage.AuditMetaFromContext(c).Actor = "cert:" + cert.Subject.CommonName
+			c.Next()
+			return
+		}
+
+		userID, login, ok := h.authenticate(c)
+		if !ok {
+			return
+		}
+
+		role, err := h.storage.GetUserRole(c, userID)
+		if err != nil {
+			problem.Internal(c, err)
+			return
+		}
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Set("operator", login)
+				c.Next()
+				return
+			}
+		}
+
+		problem.Abort(c, http.StatusForbidden, codeInsufficientRole, "insufficient role")
+	}
+}
+
+func (h handler) authenticate(c *gin.Context) (userID int, login string, ok bool) {
+	login, password, ok := c.Request.BasicAuth()
+	if !ok {
+		problem.Abort(c, http.StatusUnauthorized, problem.CodeUnauthorized, "authentication required")
+		return 0, "", false
+	}
+
+	failures, locked := h.rejectLockedLogin(c, login)
+	if locked {
+		return 0, "", false
+	}
 
 	userID, hashedPassword, err := h.storage.GetUserCredentials(c, login)
 	if err != nil {
-		httpStatusCode := http.StatusInternalServerError
 		if errors.Is(err, storage.ErrIncorrectUserCredentials) {
-			httpStatusCode = http.StatusUnauthorized
-		}
-		message := gin.H{
-			"message": err.Error(),
-			"status":  httpStatusCode,
+			h.loginFailed(c, login, nil)
 		}
-		c.AbortWithStatusJSON(httpStatusCode, message)
-		return
+		fail(c, err)
+		return 0, "", false
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)); err != nil {
-		message := gin.H{
-			"message": storage.ErrIncorrectUserCredentials.Error(),
-			"status":  http.StatusUnauthorized,
+		h.loginFailed(c, login, &userID)
+		fail(c, storage.ErrIncorrectUserCredentials)
+		return 0, "", false
+	}
+
+	storage.AuditMetaFromContext(c).Actor = login
+	if failures > 0 {
+		if err := h.storage.ResetLoginFailures(c, login); err != nil {
+			log.Println("login failures:", err.Error())
 		}
-		c.AbortWithStatusJSON(http.StatusUnauthorized, message)
+	}
+
+	return userID, login, true
+}
+
+// rejectLockedLogin responds with 429 while the login or the client IP is
+// locked out after failed attempts. It returns the failures recorded for the
+// login otherwise.
+func (h handler) rejectLockedLogin(c *gin.Context, login string) (failures int, locked bool) {
+	retryAfter, failures, err := h.storage.GetLoginLock(c, login, c.ClientIP())
+	if err != nil {
+		problem.Internal(c, err)
+		return 0, true
+	}
+	if retryAfter <= 0 {
+		return failures, false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	problem.Abort(c, http.StatusTooManyRequests, codeLoginLocked, "too many failed login attempts")
+	return failures, true
+}
+
+func (h handler) loginSucceeded(c *gin.Context, login string, userID int, failures int) {
+	h.recordLogin(c, storage.AuditLoginSucceeded, login, &userID)
+	if failures == 0 {
 		return
 	}
-	c.Set("userID", userID)
+	if err := h.storage.ResetLoginFailures(c, login); err != nil {
+		log.Println("login failures:", err.Error())
+	}
+}
+
+func (h handler) loginFailed(c *gin.Context, login string, userID *int) {
+	h.recordLogin(c, storage.AuditLoginFailed, login, userID)
+
+	failures, retryAfter, err := h.storage.RegisterLoginFailure(c, login, c.ClientIP())
+	if err != nil {
+		log.Println("login failures:", err.Error())
+		return
+	}
+	if failures > 0 {
+		log.Printf("security: failed login for %q from %s, attempt %d, locked for %s", login, c.ClientIP(), failures, retryAfter)
+	}
+}
+
+func (h handler) recordLogin(c *gin.Context, eventType string, login string, userID *int) {
+	storage.AuditMetaFromContext(c).Actor = login
+	if err := h.storage.RecordAuditEvent(c, eventType, userID, nil, gin.H{"login": login}); err != nil {
+		log.Println("audit:", err.Error())
+	}
+}
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (h handler) Idempotent(c *gin.Context) {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" {
+		c.Next()
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		badRequest(c, "request body can not be read")
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + " " + c.FullPath() + "\n"))
+	hash.Write(body)
+	requestHash := hex.EncodeToString(hash.Sum(nil))
+
+	userID := c.MustGet("userID").(int)
+	stored, err := h.storage.ReserveIdempotencyKey(c, key, requestHash, userID, h.cfg.IdempotencyTTL)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if stored != nil {
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(stored.StatusCode, stored.ContentType, stored.Body)
+		c.Abort()
+		return
+	}
+
+	recorder := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
 	c.Next()
+
+	if recorder.Status() >= http.StatusInternalServerError {
+		if err := h.storage.ReleaseIdempotencyKey(c, key, userID); err != nil {
+			log.Println("idempotency key "+key+":", err.Error())
+		}
+		return
+	}
+
+	response := storage.IdempotentResponse{
+		StatusCode:  recorder.Status(),
+		ContentType: recorder.Header().Get("Content-Type"),
+		Body:        recorder.body.Bytes(),
+	}
+	if err := h.storage.SaveIdempotentResponse(c, key, userID, response); err != nil {
+		log.Println("idempotency key "+key+":", err.Error())
+	}
 }
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ddyachkov/gophermart/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const maxPageLimit = 1000
+
+var errWrongQueryParams = errors.New("wrong query parameters")
+
+func parsePage(c *gin.Context) (page storage.Page, err error) {
+	if limit := c.Query("limit"); limit != "" {
+		page.Limit, err = strconv.Atoi(limit)
+		if err != nil || page.Limit <= 0 || page.Limit > maxPageLimit {
+			return page, errWrongQueryParams
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := storage.ParseCursor(cursor)
+		if err != nil {
+			return page, err
+		}
+		page.After = &after
+	}
+
+	switch c.DefaultQuery("sort", "asc") {
+	case "asc":
+	case "desc":
+		page.Desc = true
+	default:
+		return page, errWrongQueryParams
+	}
+
+	return page, nil
+}
+
+func parseTime(c *gin.Context, key string) (t time.Time, err error) {
+	value := c.Query(key)
+	if value == "" {
+		return t, nil
+	}
+
+	t, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, errWrongQueryParams
+	}
+
+	return t, nil
+}
+
+func parseSum(c *gin.Context, key string) (sum *float32, err error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	sum64, err := strconv.ParseFloat(value, 32)
+	if err != nil || sum64 < 0 {
+		return nil, errWrongQueryParams
+	}
+	sum32 := float32(sum64)
+
+	return &sum32, nil
+}
+
+func parseList(c *gin.Context, key string) (list []string) {
+	for _, value := range c.QueryArray(key) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+func setNextPageHeaders(c *gin.Context, next *storage.Cursor) {
+	if next == nil {
+		return
+	}
+
+	query := c.Request.URL.Query()
+	query.Set("cursor", next.String())
+	nextURL := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+
+	c.Header("X-Next-Cursor", next.String())
+	c.Header("Link", "<"+nextURL.String()+">; rel=\"next\"")
+}
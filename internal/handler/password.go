@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ddyachkov/gophermart/internal/problem"
+	"github.com/ddyachkov/gophermart/internal/storage"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (h handler) ChangePassword(c *gin.Context) {
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	userID := c.MustGet("userID").(int)
+	user, err := h.storage.GetUser(c, userID)
+	if err != nil {
+		problem.Internal(c, err)
+		return
+	}
+	_, hashedPassword, err := h.storage.GetUserCredentials(c, user.Login)
+	if err != nil {
+		problem.Internal(c, err)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(request.CurrentPassword)); err != nil {
+		problem.Abort(c, http.StatusForbidden, codeWrongPassword, "current password is incorrect")
+		return
+	}
+
+	h.setPassword(c, request.NewPassword, user.Login, func(hashedPassword string) error {
+		return h.storage.ChangePassword(c, userID, hashedPassword)
+	})
+}
+
+func (h handler) RequestPasswordReset(c *gin.Context) {
+	var request struct {
+		Login string `json:"login"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.Login == "" {
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	// Unknown logins are limited as well, otherwise only the existing ones
+	// would ever be answered with 429.
+	if retryAfter, ok := h.resets.Allow("login:" + strings.ToLower(request.Login)); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		problem.Abort(c, http.StatusTooManyRequests, problem.CodeRateLimited, "too many password reset requests for the login")
+		return
+	}
+
+	token, expiresAt, err := h.storage.CreatePasswordResetToken(c, request.Login, h.cfg.PasswordResetTTL)
+	switch {
+	case err == nil:
+		// Delivery is slower than a lookup of a missing login, so it is kept
+		// out of the response time.
+		go func() {
+			if err := h.notifier.NotifyPasswordReset(context.Background(), request.Login, token, expiresAt); err != nil {
+				log.Println("password reset:", err.Error())
+			}
+		}()
+	case err != storage.ErrUserNotFound:
+		problem.Internal(c, err)
+		return
+	}
+
+	// The response is the same for unknown logins, so that the endpoint can not
+	// be used to enumerate users.
+	message := gin.H{
+		"message": "if the login exists, a password reset token has been sent",
+		"status":  http.StatusAccepted,
+	}
+	c.JSON(http.StatusAccepted, message)
+}
+
+func (h handler) ConfirmPasswordReset(c *gin.Context) {
+	var request struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.Token == "" {
+		badRequest(c, "wrong request format")
+		return
+	}
+
+	login, err := h.storage.GetPasswordResetLogin(c, request.Token)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	h.setPassword(c, request.NewPassword, login, func(hashedPassword string) error {
+		return h.storage.ResetPassword(c, request.Token, hashedPassword)
+	})
+}
+
+func (h handler) setPassword(c *gin.Context, password string, login string, store func(hashedPassword string) error) {
+	if violations := h.passwordPolicy().Validate(password, login); len(violations) > 0 {
+		problem.Validation(c, "validation failed", violations)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		problem.Internal(c, err)
+		return
+	}
+
+	if err := store(string(hashedPassword)); err != nil {
+		fail(c, err)
+		return
+	}
+
+	message := gin.H{
+		"message": "password changed",
+		"status":  http.StatusOK,
+	}
+	c.JSON(http.StatusOK, message)
+}
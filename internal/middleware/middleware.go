@@ -2,18 +2,43 @@ package middleware
 
 import (
 	"compress/gzip"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
 	"net/http"
+	"regexp"
 	"strings"
 
+	"github.com/ddyachkov/gophermart/internal/problem"
 	"github.com/gin-gonic/gin"
 )
 
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID takes the request ID from the client or generates one, and exposes
+// it as "requestID" and in the response header.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			buf := make([]byte, 8)
+			_, _ = rand.Read(buf)
+			requestID = hex.EncodeToString(buf)
+		}
+		c.Set("requestID", requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
 func Decompress() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if strings.Contains(c.GetHeader("Content-Encoding"), "gzip") {
 			gz, err := gzip.NewReader(c.Request.Body)
 			if err != nil {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error(), "status": http.StatusInternalServerError})
+				problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidRequest, "request body is not valid gzip")
 				return
 			}
 			c.Request.Body = gz
@@ -22,3 +47,45 @@ func Decompress() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+func ClientCertificate(c *gin.Context) *x509.Certificate {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 || len(c.Request.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return c.Request.TLS.VerifiedChains[0][0]
+}
+
+// ClientCertificateAllowed reports whether a verified certificate matches an
+// entry of the allow-list: "CN:<common name>", "OU:<organizational unit>",
+// "DNS:<name>", "EMAIL:<address>" or "URI:<uri>". An empty list allows nothing.
+func ClientCertificateAllowed(cert *x509.Certificate, allow []string) bool {
+	for _, entry := range allow {
+		kind, value, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || value == "" {
+			continue
+		}
+
+		var values []string
+		switch strings.ToUpper(kind) {
+		case "CN":
+			values = []string{cert.Subject.CommonName}
+		case "OU":
+			values = cert.Subject.OrganizationalUnit
+		case "DNS":
+			values = cert.DNSNames
+		case "EMAIL":
+			values = cert.EmailAddresses
+		case "URI":
+			for _, uri := range cert.URIs {
+				values = append(values, uri.String())
+			}
+		}
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+	}
+
+	return false
+}
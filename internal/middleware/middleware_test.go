@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCertificateAllowed(t *testing.T) {
+	uri, err := url.Parse("spiffe://gophermart/operator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "backoffice", OrganizationalUnit: []string{"support"}},
+		DNSNames:       []string{"ops.gophermart.local"},
+		EmailAddresses: []string{"ops@gophermart.local"},
+		URIs:           []*url.URL{uri},
+	}
+
+	tests := []struct {
+		name  string
+		allow []string
+		want  bool
+	}{
+		{
+			name:  "Positive_CommonName",
+			allow: []string{"CN:backoffice"},
+			want:  true,
+		},
+		{
+			name:  "Positive_OrganizationalUnit",
+			allow: []string{"CN:other", "OU:support"},
+			want:  true,
+		},
+		{
+			name:  "Positive_DNSName",
+			allow: []string{"dns:ops.gophermart.local"},
+			want:  true,
+		},
+		{
+			name:  "Positive_Email",
+			allow: []string{"EMAIL:ops@gophermart.local"},
+			want:  true,
+		},
+		{
+			name:  "Positive_URI",
+			allow: []string{"URI:spiffe://gophermart/operator"},
+			want:  true,
+		},
+		{
+			name:  "Negative_EmptyList",
+			allow: nil,
+			want:  false,
+		},
+		{
+			name:  "Negative_NotListed",
+			allow: []string{"CN:other", "OU:billing"},
+			want:  false,
+		},
+		{
+			name:  "Negative_MalformedEntry",
+			allow: []string{"backoffice", "CN:"},
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ClientCertificateAllowed(cert, tt.allow))
+		})
+	}
+}
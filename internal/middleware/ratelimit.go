@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ddyachkov/gophermart/internal/config"
+	"github.com/ddyachkov/gophermart/internal/problem"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter keeps a token bucket per client. A bucket refills completely in
+// one period of the configured limit.
+type RateLimiter struct {
+	limit rate.Limit
+	burst int
+	// idle is the time a bucket needs to refill, after that it is
+	// indistinguishable from a new one and can be dropped.
+	idle time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type rateLimitState struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// NewRateLimiter returns nil when the limit is disabled.
+func NewRateLimiter(limit config.RateLimit) *RateLimiter {
+	if !limit.Enabled() {
+		return nil
+	}
+	return &RateLimiter{
+		limit:   rate.Limit(float64(limit.Requests) / limit.Period.Seconds()),
+		burst:   limit.Requests,
+		idle:    limit.Period,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *RateLimiter) take(key string, now time.Time) (state rateLimitState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > l.idle {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > l.idle {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	state.allowed = b.limiter.AllowN(now, 1)
+	tokens := b.limiter.TokensAt(now)
+	state.remaining = int(math.Floor(tokens))
+	state.reset = l.refill(float64(l.burst) - tokens)
+	if !state.allowed {
+		state.retryAfter = l.refill(1 - tokens)
+	}
+
+	return state
+}
+
+// Allow takes a token for a key which is not derived from the request, e.g. a
+// login from its body. A nil limiter allows everything.
+func (l *RateLimiter) Allow(key string) (retryAfter time.Duration, ok bool) {
+	if l == nil {
+		return 0, true
+	}
+	state := l.take(key, time.Now())
+	return state.retryAfter, state.allowed
+}
+
+func (l *RateLimiter) refill(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / float64(l.limit) * float64(time.Second))
+}
+
+// RateLimit limits requests per authenticated user or operator, or per client
+// IP when the request is anonymous, so it has to run after authentication in
+// the chain.
+func RateLimit(l *RateLimiter) gin.HandlerFunc {
+	return rateLimit(l, func(c *gin.Context) string {
+		if userID, ok := c.Get("userID"); ok {
+			return "user:" + strconv.Itoa(userID.(int))
+		}
+		if operator := c.GetString("operator"); operator != "" {
+			return "operator:" + operator
+		}
+		return "ip:" + c.ClientIP()
+	})
+}
+
+// RateLimitByIP limits requests per client IP whoever is behind them. It runs
+// before authentication, so that requests with missing or wrong credentials
+// are limited as well.
+func RateLimitByIP(l *RateLimiter) gin.HandlerFunc {
+	return rateLimit(l, func(c *gin.Context) string {
+		return "ip:" + c.ClientIP()
+	})
+}
+
+func rateLimit(l *RateLimiter, key func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			c.Next()
+			return
+		}
+
+		state := l.take(key(c), time.Now())
+		c.Header("RateLimit-Limit", strconv.Itoa(l.burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(state.remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(seconds(state.reset)))
+		if !state.allowed {
+			c.Header("Retry-After", strconv.Itoa(seconds(state.retryAfter)))
+			problem.Abort(c, http.StatusTooManyRequests, problem.CodeRateLimited, "rate limit exceeded")
+			return
+		}
+		c.Next()
+	}
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
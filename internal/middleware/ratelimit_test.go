@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ddyachkov/gophermart/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_take(t *testing.T) {
+	limiter := NewRateLimiter(config.RateLimit{Requests: 2, Period: time.Second})
+	now := time.Now()
+
+	state := limiter.take("ip:192.0.2.1", now)
+	assert.True(t, state.allowed)
+	assert.Equal(t, 1, state.remaining)
+
+	state = limiter.take("ip:192.0.2.1", now)
+	assert.True(t, state.allowed)
+	assert.Equal(t, 0, state.remaining)
+	assert.Equal(t, time.Second, state.reset)
+
+	state = limiter.take("ip:192.0.2.1", now)
+	assert.False(t, state.allowed)
+	assert.Equal(t, 500*time.Millisecond, state.retryAfter)
+
+	state = limiter.take("ip:192.0.2.2", now)
+	assert.True(t, state.allowed, "buckets are per key")
+
+	state = limiter.take("ip:192.0.2.1", now.Add(500*time.Millisecond))
+	assert.True(t, state.allowed, "a token is refilled after period/requests")
+
+	limiter.take("ip:192.0.2.2", now.Add(3*time.Second))
+	assert.Len(t, limiter.buckets, 1, "idle buckets are dropped")
+}
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			c.Set("userID", len(user))
+		}
+	}, RateLimit(NewRateLimiter(config.RateLimit{Requests: 1, Period: time.Minute})))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(user string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if user != "" {
+			r.Header.Set("X-User", user)
+		}
+		router.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	res := send("")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "1", res.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "0", res.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", res.Header.Get("RateLimit-Reset"))
+
+	res = send("")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "60", res.Header.Get("Retry-After"))
+
+	res = send("user")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode, "authenticated users are limited separately from their IP")
+
+	res = send("user")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+
+	passthrough := gin.New()
+	passthrough.Use(RateLimit(NewRateLimiter(config.RateLimit{})))
+	passthrough.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	w := httptest.NewRecorder()
+	passthrough.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimitByIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RateLimitByIP(NewRateLimiter(config.RateLimit{Requests: 1, Period: time.Minute})), func(c *gin.Context) {
+		c.Set("userID", 1)
+	}, RateLimit(NewRateLimiter(config.RateLimit{Requests: 10, Period: time.Minute})))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(remoteAddr string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("192.0.2.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, send("192.0.2.1:1234"), "the IP is limited before the user is known")
+	assert.Equal(t, http.StatusOK, send("192.0.2.2:1234"))
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	l := NewRateLimiter(config.RateLimit{Requests: 1, Period: time.Minute})
+
+	_, ok := l.Allow("login:user")
+	assert.True(t, ok)
+	retryAfter, ok := l.Allow("login:user")
+	assert.False(t, ok)
+	assert.InDelta(t, time.Minute, retryAfter, float64(time.Second))
+	_, ok = l.Allow("login:other")
+	assert.True(t, ok, "keys are limited separately")
+
+	var disabled *RateLimiter
+	_, ok = disabled.Allow("login:user")
+	assert.True(t, ok)
+}
@@ -0,0 +1,170 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Document is the subset of OpenAPI 3 the server needs to route and validate
+// requests. References are resolved by Load.
+type Document struct {
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas    map[string]*Schema    `json:"schemas"`
+		Parameters map[string]*Parameter `json:"parameters"`
+	} `json:"components"`
+}
+
+type Operation struct {
+	OperationID string       `json:"operationId"`
+	Parameters  []*Parameter `json:"parameters"`
+	RequestBody *RequestBody `json:"requestBody"`
+	// APIKeyScope is the scope an API key needs for the operation, empty when
+	// the operation is not available to API keys.
+	APIKeyScope string `json:"x-api-key-scope"`
+}
+
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref              string             `json:"$ref"`
+	Type             string             `json:"type"`
+	Format           string             `json:"format"`
+	Nullable         bool               `json:"nullable"`
+	Enum             []any              `json:"enum"`
+	Minimum          *float64           `json:"minimum"`
+	Maximum          *float64           `json:"maximum"`
+	ExclusiveMinimum bool               `json:"exclusiveMinimum"`
+	Required         []string           `json:"required"`
+	Properties       map[string]*Schema `json:"properties"`
+	Items            *Schema            `json:"items"`
+	AllOf            []*Schema          `json:"allOf"`
+	OneOf            []*Schema          `json:"oneOf"`
+}
+
+// Spec returns the document as it is served to clients.
+func Spec() []byte {
+	return spec
+}
+
+func Load() (*Document, error) {
+	var d Document
+	if err := json.Unmarshal(spec, &d); err != nil {
+		return nil, err
+	}
+
+	for path, operations := range d.Paths {
+		for method, operation := range operations {
+			for i, parameter := range operation.Parameters {
+				if parameter.Ref != "" {
+					resolved, ok := d.Components.Parameters[strings.TrimPrefix(parameter.Ref, "#/components/parameters/")]
+					if !ok {
+						return nil, errors.New("openapi: unknown parameter " + parameter.Ref + " in " + method + " " + path)
+					}
+					operation.Parameters[i] = resolved
+				}
+				if err := d.resolve(operation.Parameters[i].Schema); err != nil {
+					return nil, err
+				}
+			}
+			if operation.RequestBody != nil {
+				for _, media := range operation.RequestBody.Content {
+					if err := d.resolve(media.Schema); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	return &d, nil
+}
+
+// MustLoad is for the embedded document, which is checked by the tests.
+func MustLoad() *Document {
+	d, err := Load()
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// resolve replaces references with copies of the component schemas. The spec
+// has no recursive schemas, so there is no cycle detection.
+func (d *Document) resolve(s *Schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		resolved, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			return errors.New("openapi: unknown schema " + s.Ref)
+		}
+		ref := s.Ref
+		*s = *resolved
+		s.Ref = ""
+		if err := d.resolve(s); err != nil {
+			return errors.New("openapi: " + ref + ": " + err.Error())
+		}
+		return nil
+	}
+
+	for _, property := range s.Properties {
+		if err := d.resolve(property); err != nil {
+			return err
+		}
+	}
+	for _, sub := range s.AllOf {
+		if err := d.resolve(sub); err != nil {
+			return err
+		}
+	}
+	for _, sub := range s.OneOf {
+		if err := d.resolve(sub); err != nil {
+			return err
+		}
+	}
+
+	return d.resolve(s.Items)
+}
+
+// Operation looks an operation up by the method and the route path, both in
+// the gin (":id") and the OpenAPI ("{id}") notation.
+func (d *Document) Operation(method string, path string) *Operation {
+	operations, ok := d.Paths[ToOpenAPIPath(path)]
+	if !ok {
+		return nil
+	}
+	return operations[strings.ToLower(method)]
+}
+
+// ToOpenAPIPath converts a gin route path to the OpenAPI notation.
+func ToOpenAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,203 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	d, err := Load()
+	require.NoError(t, err)
+
+	operation := d.Operation(http.MethodGet, "/api/user/orders/:number")
+	require.NotNil(t, operation)
+	assert.Equal(t, "getUserOrder", operation.OperationID)
+	assert.Equal(t, "orders:read", operation.APIKeyScope)
+
+	operation = d.Operation(http.MethodGet, "/api/user/orders")
+	require.NotNil(t, operation)
+	for _, parameter := range operation.Parameters {
+		assert.NotEmpty(t, parameter.Name, "parameter references are resolved")
+	}
+
+	assert.Nil(t, d.Operation(http.MethodPatch, "/api/user/orders"))
+	assert.Nil(t, d.Operation(http.MethodGet, "/api/unknown"))
+}
+
+func TestToOpenAPIPath(t *testing.T) {
+	assert.Equal(t, "/api/admin/users/{id}/role", ToOpenAPIPath("/api/admin/users/:id/role"))
+	assert.Equal(t, "/static/{path}", ToOpenAPIPath("/static/*path"))
+	assert.Equal(t, "/api/user/balance", ToOpenAPIPath("/api/user/balance"))
+}
+
+func TestDocument_Validator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(MustLoad().Validator())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.POST("/api/admin/campaigns", ok)
+	router.GET("/api/user/orders", ok)
+	router.PUT("/api/admin/users/:id/role", ok)
+	router.POST("/api/user/orders/batch", ok)
+	router.POST("/api/user/balance/withdraw", ok)
+	router.GET("/unspecified", ok)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		code        int
+		field       string
+	}{
+		{
+			name:   "Positive_Body",
+			method: http.MethodPost,
+			path:   "/api/admin/campaigns",
+			body:   `{"name": "weekend", "kind": "fixed", "value": 10, "starts_at": "2026-01-01T00:00:00Z", "ends_at": "2026-01-02T00:00:00Z", "budget": null}`,
+			code:   http.StatusOK,
+		},
+		{
+			name:   "Negative_MissingProperty",
+			method: http.MethodPost,
+			path:   "/api/admin/campaigns",
+			body:   `{"name": "weekend", "kind": "fixed", "value": 10, "starts_at": "2026-01-01T00:00:00Z"}`,
+			code:   http.StatusBadRequest,
+			field:  "body.ends_at",
+		},
+		{
+			name:   "Negative_Enum",
+			method: http.MethodPost,
+			path:   "/api/admin/campaigns",
+			body:   `{"name": "weekend", "kind": "percent", "value": 10, "starts_at": "2026-01-01T00:00:00Z", "ends_at": "2026-01-02T00:00:00Z"}`,
+			code:   http.StatusBadRequest,
+			field:  "body.kind",
+		},
+		{
+			name:   "Negative_DateTime",
+			method: http.MethodPost,
+			path:   "/api/admin/campaigns",
+			body:   `{"name": "weekend", "kind": "fixed", "value": 10, "starts_at": "tomorrow", "ends_at": "2026-01-02T00:00:00Z"}`,
+			code:   http.StatusBadRequest,
+			field:  "body.starts_at",
+		},
+		{
+			name:   "Negative_Type",
+			method: http.MethodPost,
+			path:   "/api/admin/campaigns",
+			body:   `{"name": "weekend", "kind": "fixed", "value": "10", "starts_at": "2026-01-01T00:00:00Z", "ends_at": "2026-01-02T00:00:00Z"}`,
+			code:   http.StatusBadRequest,
+			field:  "body.value",
+		},
+		{
+			name:   "Negative_MalformedJSON",
+			method: http.MethodPost,
+			path:   "/api/admin/campaigns",
+			body:   `{"name": `,
+			code:   http.StatusBadRequest,
+			field:  "body",
+		},
+		{
+			name:   "Negative_EmptyBody",
+			method: http.MethodPost,
+			path:   "/api/admin/campaigns",
+			code:   http.StatusBadRequest,
+			field:  "body",
+		},
+		{
+			name:   "Positive_Query",
+			method: http.MethodGet,
+			path:   "/api/user/orders?limit=10&sort=desc&uploaded_from=2026-01-01T00:00:00Z",
+			code:   http.StatusOK,
+		},
+		{
+			name:   "Negative_QueryMaximum",
+			method: http.MethodGet,
+			path:   "/api/user/orders?limit=1001",
+			code:   http.StatusBadRequest,
+			field:  "query.limit",
+		},
+		{
+			name:   "Negative_QueryType",
+			method: http.MethodGet,
+			path:   "/api/user/orders?limit=ten",
+			code:   http.StatusBadRequest,
+			field:  "query.limit",
+		},
+		{
+			name:   "Negative_PathType",
+			method: http.MethodPut,
+			path:   "/api/admin/users/first/role",
+			body:   `{"role": "admin"}`,
+			code:   http.StatusBadRequest,
+			field:  "path.id",
+		},
+		{
+			name:        "Positive_BatchPlainText",
+			method:      http.MethodPost,
+			path:        "/api/user/orders/batch",
+			contentType: "text/plain",
+			body:        "12345678903\n",
+			code:        http.StatusOK,
+		},
+		{
+			name:        "Negative_BatchJSON",
+			method:      http.MethodPost,
+			path:        "/api/user/orders/batch",
+			contentType: "application/json",
+			body:        `[12345678903]`,
+			code:        http.StatusBadRequest,
+			field:       "body[0]",
+		},
+		{
+			name:   "Positive_Withdrawal",
+			method: http.MethodPost,
+			path:   "/api/user/balance/withdraw",
+			body:   `{"order": "12345678903", "sum": 0.5}`,
+			code:   http.StatusOK,
+		},
+		{
+			name:   "Negative_NegativeSum",
+			method: http.MethodPost,
+			path:   "/api/user/balance/withdraw",
+			body:   `{"order": "12345678903", "sum": -10}`,
+			code:   http.StatusBadRequest,
+			field:  "body.sum",
+		},
+		{
+			name:   "Negative_ZeroSum",
+			method: http.MethodPost,
+			path:   "/api/user/balance/withdraw",
+			body:   `{"order": "12345678903", "sum": 0}`,
+			code:   http.StatusBadRequest,
+			field:  "body.sum",
+		},
+		{
+			name:   "Positive_UnspecifiedRoute",
+			method: http.MethodGet,
+			path:   "/unspecified?limit=ten",
+			code:   http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.code, w.Code)
+			if tt.field != "" {
+				assert.Contains(t, w.Body.String(), `"field":"`+tt.field+`"`)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ddyachkov/gophermart/internal/problem"
+	"github.com/ddyachkov/gophermart/internal/validation"
+	"github.com/gin-gonic/gin"
+)
+
+// Validator rejects requests whose parameters or JSON body do not match the
+// operation of the matched route. Routes missing from the document are let
+// through, the drift test keeps them in sync.
+func (d *Document) Validator() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		operation := d.Operation(c.Request.Method, c.FullPath())
+		if operation == nil {
+			c.Next()
+			return
+		}
+
+		violations := operation.validateParameters(c)
+		bodyViolations, err := operation.validateBody(c)
+		if err != nil {
+			problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidRequest, "request body can not be read")
+			return
+		}
+		violations = append(violations, bodyViolations...)
+		if len(violations) > 0 {
+			problem.Validation(c, "request does not match the API specification", violations)
+			return
+		}
+		c.Next()
+	}
+}
+
+func (o *Operation) validateParameters(c *gin.Context) (violations []validation.FieldError) {
+	for _, parameter := range o.Parameters {
+		var value string
+		var present bool
+		switch parameter.In {
+		case "path":
+			value = c.Param(parameter.Name)
+			present = value != ""
+		case "query":
+			value, present = c.GetQuery(parameter.Name)
+		case "header":
+			value = c.GetHeader(parameter.Name)
+			present = value != ""
+		default:
+			continue
+		}
+
+		field := parameter.In + "." + parameter.Name
+		if !present {
+			if parameter.Required {
+				violations = append(violations, validation.FieldError{Field: field, Message: "is required"})
+			}
+			continue
+		}
+		if parameter.Schema != nil {
+			violations = append(violations, parameter.Schema.validateString(value, field)...)
+		}
+	}
+
+	return violations
+}
+
+// validateBody checks JSON bodies only; other media types are parsed by the
+// handlers themselves.
+func (o *Operation) validateBody(c *gin.Context) (violations []validation.FieldError, err error) {
+	if o.RequestBody == nil {
+		return nil, nil
+	}
+	media, ok := o.RequestBody.Content[gin.MIMEJSON]
+	if !ok || media.Schema == nil {
+		return nil, nil
+	}
+	// Operations accepting several media types are told apart by Content-Type,
+	// JSON-only ones are validated whatever the client sends.
+	if len(o.RequestBody.Content) > 1 && !strings.Contains(c.ContentType(), "json") {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if o.RequestBody.Required {
+			return []validation.FieldError{{Field: "body", Message: "is required"}}, nil
+		}
+		return nil, nil
+	}
+
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return []validation.FieldError{{Field: "body", Message: "must be valid JSON"}}, nil
+	}
+
+	return media.Schema.Validate(value, "body"), nil
+}
+
+// validateString checks a parameter value, which always arrives as a string.
+func (s *Schema) validateString(value string, field string) []validation.FieldError {
+	switch s.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return []validation.FieldError{{Field: field, Message: "must be an integer"}}
+		}
+		return s.Validate(json.Number(strconv.FormatInt(n, 10)), field)
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return []validation.FieldError{{Field: field, Message: "must be a number"}}
+		}
+		return s.Validate(json.Number(value), field)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return []validation.FieldError{{Field: field, Message: "must be a boolean"}}
+		}
+		return s.Validate(b, field)
+	}
+
+	return s.Validate(value, field)
+}
+
+// Validate checks a value decoded with json.Decoder.UseNumber against the
+// schema.
+func (s *Schema) Validate(value any, field string) (violations []validation.FieldError) {
+	if value == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return []validation.FieldError{{Field: field, Message: "must not be null"}}
+	}
+
+	for _, sub := range s.AllOf {
+		violations = append(violations, sub.Validate(value, field)...)
+	}
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, sub := range s.OneOf {
+			if len(sub.Validate(value, field)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			violations = append(violations, validation.FieldError{Field: field, Message: "must match exactly one of the allowed schemas"})
+		}
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return append(violations, validation.FieldError{Field: field, Message: "must be an object"})
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				violations = append(violations, validation.FieldError{Field: field + "." + name, Message: "is required"})
+			}
+		}
+		for name, property := range s.Properties {
+			if propertyValue, ok := object[name]; ok {
+				violations = append(violations, property.Validate(propertyValue, field+"."+name)...)
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return append(violations, validation.FieldError{Field: field, Message: "must be an array"})
+		}
+		if s.Items != nil {
+			for i, item := range array {
+				violations = append(violations, s.Items.Validate(item, field+"["+strconv.Itoa(i)+"]")...)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return append(violations, validation.FieldError{Field: field, Message: "must be a string"})
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				violations = append(violations, validation.FieldError{Field: field, Message: "must be an RFC 3339 date-time"})
+			}
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return append(violations, validation.FieldError{Field: field, Message: "must be a " + s.Type})
+		}
+		if s.Type == "integer" {
+			if _, err := number.Int64(); err != nil {
+				return append(violations, validation.FieldError{Field: field, Message: "must be an integer"})
+			}
+		}
+		n, err := number.Float64()
+		if err != nil {
+			return append(violations, validation.FieldError{Field: field, Message: "must be a number"})
+		}
+		if s.Minimum != nil && s.ExclusiveMinimum && n <= *s.Minimum {
+			violations = append(violations, validation.FieldError{Field: field, Message: "must be greater than " + strconv.FormatFloat(*s.Minimum, 'f', -1, 64)})
+		} else if s.Minimum != nil && n < *s.Minimum {
+			violations = append(violations, validation.FieldError{Field: field, Message: "must be at least " + strconv.FormatFloat(*s.Minimum, 'f', -1, 64)})
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			violations = append(violations, validation.FieldError{Field: field, Message: "must be at most " + strconv.FormatFloat(*s.Maximum, 'f', -1, 64)})
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return append(violations, validation.FieldError{Field: field, Message: "must be a boolean"})
+		}
+	}
+
+	if len(s.Enum) > 0 && !s.allows(value) {
+		violations = append(violations, validation.FieldError{Field: field, Message: "must be one of the allowed values"})
+	}
+
+	return violations
+}
+
+func (s *Schema) allows(value any) bool {
+	for _, allowed := range s.Enum {
+		if number, ok := value.(json.Number); ok {
+			if f, err := number.Float64(); err == nil && f == allowed {
+				return true
+			}
+			continue
+		}
+		if value == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+// Package problem renders error responses as RFC 7807 problem details.
+package problem
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/ddyachkov/gophermart/internal/validation"
+	"github.com/gin-gonic/gin"
+)
+
+const ContentType = "application/problem+json"
+
+// Stable error codes shared by several packages. Codes of domain errors are
+// kept next to the mapping of storage errors in the handler package.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeValidationFailed = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal_error"
+)
+
+// Details is the problem document. Code is the stable machine readable error
+// code clients are expected to branch on.
+type Details struct {
+	Type      string                  `json:"type"`
+	Title     string                  `json:"title"`
+	Status    int                     `json:"status"`
+	Detail    string                  `json:"detail,omitempty"`
+	Instance  string                  `json:"instance,omitempty"`
+	Code      string                  `json:"code"`
+	RequestID string                  `json:"request_id,omitempty"`
+	Errors    []validation.FieldError `json:"errors,omitempty"`
+}
+
+func New(status int, code string, detail string) Details {
+	return Details{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Abort responds with the problem and stops the handler chain.
+func Abort(c *gin.Context, status int, code string, detail string) {
+	AbortWith(c, New(status, code, detail))
+}
+
+func AbortWith(c *gin.Context, p Details) {
+	p.Instance = c.Request.URL.Path
+	p.RequestID = c.GetString("requestID")
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// Validation responds with 400 listing the offending fields.
+func Validation(c *gin.Context, detail string, violations []validation.FieldError) {
+	p := New(http.StatusBadRequest, CodeValidationFailed, detail)
+	p.Errors = violations
+	AbortWith(c, p)
+}
+
+// Internal logs the error and responds with 500 without exposing it.
+func Internal(c *gin.Context, err error) {
+	log.Printf("request %s %s %s: %s", c.GetString("requestID"), c.Request.Method, c.Request.URL.Path, err.Error())
+	Abort(c, http.StatusInternalServerError, CodeInternal, "internal server error")
+}
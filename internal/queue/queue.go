@@ -32,7 +32,7 @@ func NewQueue(a accrual.Accrualler, st *storage.DBStorage) (queue *Queue) {
 }
 
 func (aq *Queue) Start() {
-	qCtx, cancel := context.WithCancel(context.Background())
+	qCtx, cancel := context.WithCancel(storage.WithAuditMeta(context.Background(), &storage.AuditMeta{Actor: "accrual queue"}))
 	aq.cancel = cancel
 
 	orders, err := aq.storage.GetNewOrders(qCtx)
@@ -54,6 +54,9 @@ func (aq *Queue) Start() {
 			aq.limiter.Wait(qCtx)
 			go func() {
 				delay, err := aq.service.OrderAccrual(qCtx, &order)
+				if err := aq.storage.IncrementOrderAttempts(qCtx, order.Number); err != nil {
+					log.Println("order #"+order.Number+":", err.Error())
+				}
 				if err != nil {
 					log.Println("order #"+order.Number+":", err.Error())
 					return
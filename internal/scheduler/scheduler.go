@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type Job func(ctx context.Context) error
+
+type task struct {
+	name     string
+	interval time.Duration
+	job      Job
+}
+
+type Scheduler struct {
+	tasks  []task
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler() (scheduler *Scheduler) {
+	return &Scheduler{}
+}
+
+func (s *Scheduler) Every(name string, interval time.Duration, job Job) {
+	s.tasks = append(s.tasks, task{
+		name:     name,
+		interval: interval,
+		job:      job,
+	})
+}
+
+func (s *Scheduler) Start() {
+	sCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go func(t task) {
+			defer s.wg.Done()
+
+			ticker := time.NewTicker(t.interval)
+			defer ticker.Stop()
+
+			// The job runs right away, an instance restarted more often than
+			// the interval would never run it otherwise.
+			for {
+				if err := t.job(sCtx); err != nil && sCtx.Err() == nil {
+					log.Println(t.name+":", err.Error())
+				}
+
+				select {
+				case <-sCtx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(t)
+	}
+}
+
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
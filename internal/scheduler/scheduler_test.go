@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler_Every(t *testing.T) {
+	var runs int32
+	scheduler := NewScheduler()
+	scheduler.Every("counter", 10*time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+
+	scheduler.Start()
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runs) >= 3
+	}, time.Second, 10*time.Millisecond)
+	scheduler.Stop()
+
+	stopped := atomic.LoadInt32(&runs)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt32(&runs))
+}
+
+func TestScheduler_RunsOnStart(t *testing.T) {
+	var runs int32
+	scheduler := NewScheduler()
+	scheduler.Every("counter", time.Hour, func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+
+	scheduler.Start()
+	defer scheduler.Stop()
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runs) == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/ddyachkov/gophermart/internal/config"
+)
+
+func NewServer(cfg *config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.RunAddress,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+func NewRedirectServer(cfg *config.ServerConfig) *http.Server {
+	_, httpsPort, _ := net.SplitHostPort(cfg.RunAddress)
+
+	redirect := func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}
+
+	return &http.Server{
+		Addr:              cfg.RedirectAddress,
+		Handler:           http.HandlerFunc(redirect),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
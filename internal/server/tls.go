@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrNoClientCACerts = errors.New("no certificates found in client CA file")
+
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+	onReload func()
+}
+
+func NewCertReloader(certFile string, keyFile string) (reloader *CertReloader, err error) {
+	reloader = &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err = reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	return cr.cert, nil
+}
+
+// OnReload registers a callback run after each successful reload. It must be
+// set before Watch is started.
+func (cr *CertReloader) OnReload(fn func()) {
+	cr.onReload = fn
+}
+
+func (cr *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTime, err := cr.lastModified()
+			if err != nil {
+				log.Println("tls certificate:", err.Error())
+				continue
+			}
+
+			cr.mu.RLock()
+			changed := modTime.After(cr.modTime)
+			cr.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err = cr.reload(); err != nil {
+				log.Println("tls certificate:", err.Error())
+				continue
+			}
+			log.Println("tls certificate reloaded")
+			if cr.onReload != nil {
+				cr.onReload()
+			}
+		}
+	}
+}
+
+func (cr *CertReloader) reload() (err error) {
+	modTime, err := cr.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.mu.Unlock()
+
+	return nil
+}
+
+func (cr *CertReloader) lastModified() (modTime time.Time, err error) {
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTime, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	return modTime, nil
+}
+
+func NewTLSConfig(reloader *CertReloader, clientCAFile string) (tlsConfig *tls.Config, err error) {
+	tlsConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	if clientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, ErrNoClientCACerts
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return tlsConfig, nil
+}
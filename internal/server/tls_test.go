@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCertificate(t *testing.T, certFile string, keyFile string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{certFile, keyFile} {
+		if err = os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertReloader_Watch(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	writeCertificate(t, certFile, keyFile, 1, time.Now().Add(-time.Minute))
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, int64(1), leaf.SerialNumber.Int64())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	writeCertificate(t, certFile, keyFile, 2, time.Now())
+
+	assert.Eventually(t, func() bool {
+		cert, err := reloader.GetCertificate(nil)
+		if err != nil {
+			return false
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		return err == nil && leaf.SerialNumber.Int64() == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNewCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, 1, time.Now())
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{
+			name:     "Positive_ValidKeyPair",
+			certFile: certFile,
+			keyFile:  keyFile,
+			wantErr:  false,
+		},
+		{
+			name:     "Negative_MissingFiles",
+			certFile: certFile + ".missing",
+			keyFile:  keyFile,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCertReloader(tt.certFile, tt.keyFile)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
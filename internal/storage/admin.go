@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+const maxUserSearchResults = 100
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUnknownRole  = errors.New("unknown role")
+)
+
+const userSummaryColumns = "u.id, u.login, u.role, u.tier, u.current, u.withdrawn, u.held, u.debt"
+
+func (s DBStorage) GetUserRole(ctx context.Context, userID int) (role string, err error) {
+	err = s.pool.QueryRow(ctx, "SELECT u.role FROM public.user u WHERE u.id = $1", userID).Scan(&role)
+	if err == pgx.ErrNoRows {
+		return role, ErrUserNotFound
+	}
+
+	return role, err
+}
+
+func (s DBStorage) SetUserRole(ctx context.Context, userID int, role string) (err error) {
+	switch role {
+	case RoleUser, RoleSupport, RoleAdmin:
+	default:
+		return ErrUnknownRole
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var previous string
+	err = tx.QueryRow(ctx, "SELECT u.role FROM public.user u WHERE u.id = $1 FOR UPDATE", userID).Scan(&previous)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.user SET role = $1 WHERE id = $2", role, userID)
+	if err != nil {
+		return err
+	}
+
+	err = recordAuditEvent(ctx, tx, AuditRoleChanged, &userID, map[string]string{"role": previous}, map[string]string{"role": role})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s DBStorage) SearchUsers(ctx context.Context, login string) (users []UserSummary, err error) {
+	err = pgxscan.Select(ctx, s.pool, &users, "SELECT "+userSummaryColumns+" FROM public.user u WHERE u.login ILIKE '%' || $1 || '%' ORDER BY u.id LIMIT $2", login, maxUserSearchResults)
+
+	return users, err
+}
+
+func (s DBStorage) GetUser(ctx context.Context, userID int) (user UserSummary, err error) {
+	err = pgxscan.Get(ctx, s.pool, &user, "SELECT "+userSummaryColumns+" FROM public.user u WHERE u.id = $1", userID)
+	if pgxscan.NotFound(err) {
+		return user, ErrUserNotFound
+	}
+
+	return user, err
+}
+
+// AdjustUserBalance credits (positive sum) or debits (negative sum) the user
+// balance outside of the accrual flow.
+func (s DBStorage) AdjustUserBalance(ctx context.Context, userID int, sum float32, reason string, operator string) (adjustment BalanceAdjustment, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return adjustment, err
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := getBalanceSnapshot(ctx, tx, userID)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return adjustment, ErrUserNotFound
+		}
+		return adjustment, err
+	}
+
+	if sum < 0 {
+		_, err = tx.Exec(ctx, "UPDATE public.user SET current = current - $1 WHERE id = $2", -sum, userID)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.CheckViolation {
+				return adjustment, ErrInsufficientFunds
+			}
+			return adjustment, err
+		}
+		if err = s.consumeLots(ctx, tx, -sum, userID); err != nil {
+			return adjustment, err
+		}
+	} else {
+		credited, err := creditBalance(ctx, tx, userID, sum)
+		if err != nil {
+			return adjustment, err
+		}
+		if err = s.createLot(ctx, tx, "", credited, userID); err != nil {
+			return adjustment, err
+		}
+	}
+
+	err = pgxscan.Get(ctx, tx, &adjustment, "INSERT INTO public.balance_adjustment (user_id, sum, reason, operator) VALUES ($1, $2, $3, $4) RETURNING id, user_id, sum, reason, operator, created_at", userID, sum, reason, operator)
+	if err != nil {
+		return adjustment, err
+	}
+
+	err = recordBalanceChange(ctx, tx, AuditBalanceAdjusted, userID, before, nil, map[string]any{"sum": sum, "reason": reason})
+	if err != nil {
+		return adjustment, err
+	}
+
+	return adjustment, tx.Commit(ctx)
+}
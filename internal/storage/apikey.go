@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ScopeOrdersWrite = "orders:write"
+	ScopeOrdersRead  = "orders:read"
+	ScopeBalanceRead = "balance:read"
+	ScopeWithdraw    = "withdraw"
+)
+
+const (
+	AuditAPIKeyCreated = "api_key.created"
+	AuditAPIKeyRevoked = "api_key.revoked"
+)
+
+const (
+	apiKeyTokenPrefix = "gm_"
+	apiKeyPrefixLen   = len(apiKeyTokenPrefix) + 8
+	// apiKeyUsagePrecision limits the last_used_at updates of busy keys.
+	apiKeyUsagePrecision = time.Minute
+)
+
+var (
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrInvalidAPIKey     = errors.New("invalid api key")
+	ErrWrongAPIKeyScopes = errors.New("api key must have a name and at least one known scope")
+)
+
+var apiKeyScopes = []string{ScopeOrdersWrite, ScopeOrdersRead, ScopeBalanceRead, ScopeWithdraw}
+
+func validAPIKeyScope(scope string) bool {
+	for _, known := range apiKeyScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey issues a new key for the user. Like reset tokens, only the hash
+// is stored and the returned key token can not be recovered later.
+func (s DBStorage) CreateAPIKey(ctx context.Context, userID int, name string, scopes []string) (key APIKey, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(scopes) == 0 {
+		return key, ErrWrongAPIKeyScopes
+	}
+	for _, scope := range scopes {
+		if !validAPIKeyScope(scope) {
+			return key, ErrWrongAPIKeyScopes
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return key, err
+	}
+	token := apiKeyTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return key, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = pgxscan.Get(ctx, tx, &key, "INSERT INTO public.api_key (user_id, name, prefix, key_hash, scopes) VALUES ($1, $2, $3, $4, $5) RETURNING id, name, prefix, scopes, created_at, last_used_at", userID, name, token[:apiKeyPrefixLen], hashToken(token), scopes)
+	if err != nil {
+		return key, err
+	}
+
+	if err = recordAuditEvent(ctx, tx, AuditAPIKeyCreated, &userID, nil, key); err != nil {
+		return key, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return key, err
+	}
+	key.Token = token
+
+	return key, nil
+}
+
+func (s DBStorage) GetAPIKeys(ctx context.Context, userID int) (keys []APIKey, err error) {
+	err = pgxscan.Select(ctx, s.pool, &keys, "SELECT k.id, k.name, k.prefix, k.scopes, k.created_at, k.last_used_at FROM public.api_key k WHERE k.user_id = $1 AND k.revoked_at IS NULL ORDER BY k.id", userID)
+
+	return keys, err
+}
+
+func (s DBStorage) RevokeAPIKey(ctx context.Context, userID int, keyID int) (err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var key APIKey
+	err = pgxscan.Get(ctx, tx, &key, "UPDATE public.api_key SET revoked_at = current_timestamp WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL RETURNING id, name, prefix, scopes, created_at, last_used_at", keyID, userID)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+
+	if err = recordAuditEvent(ctx, tx, AuditAPIKeyRevoked, &userID, key, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// AuthenticateAPIKey resolves an active key to its owner and records the use,
+// at most once per apiKeyUsagePrecision.
+func (s DBStorage) AuthenticateAPIKey(ctx context.Context, token string) (userID int, login string, key APIKey, err error) {
+	if !strings.HasPrefix(token, apiKeyTokenPrefix) {
+		return 0, "", key, ErrInvalidAPIKey
+	}
+
+	var recent bool
+	err = s.pool.QueryRow(ctx, "SELECT u.id, u.login, k.id, k.name, k.prefix, k.scopes, k.created_at, k.last_used_at, coalesce(k.last_used_at > current_timestamp - make_interval(secs => $2), false) FROM public.api_key k JOIN public.user u ON u.id = k.user_id WHERE k.key_hash = $1 AND k.revoked_at IS NULL", hashToken(token), apiKeyUsagePrecision.Seconds()).
+		Scan(&userID, &login, &key.ID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &key.LastUsedAt, &recent)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, "", key, ErrInvalidAPIKey
+		}
+		return 0, "", key, err
+	}
+	if recent {
+		return userID, login, key, nil
+	}
+
+	err = s.pool.QueryRow(ctx, "UPDATE public.api_key SET last_used_at = current_timestamp WHERE id = $1 RETURNING last_used_at", key.ID).Scan(&key.LastUsedAt)
+
+	return userID, login, key, err
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	AuditUserRegistered     = "user.registered"
+	AuditLoginSucceeded     = "login.succeeded"
+	AuditLoginFailed        = "login.failed"
+	AuditWithdrawal         = "balance.withdrawal"
+	AuditOrderStatusChanged = "order.status_changed"
+	AuditBalanceAdjusted    = "balance.adjusted"
+	AuditWithdrawalReversed = "balance.withdrawal_reversed"
+	AuditClawback           = "balance.clawback"
+	AuditTransfer           = "balance.transfer"
+	AuditHoldCreated        = "hold.created"
+	AuditHoldReleased       = "hold.released"
+	AuditPointsExpired      = "balance.points_expired"
+	AuditRoleChanged        = "user.role_changed"
+	AuditConfigReloaded     = "config.reloaded"
+)
+
+const auditSystemActor = "system"
+
+var ErrNoAuditEventsFound = errors.New("no audit events found")
+
+type auditContextKey struct{}
+
+// AuditMeta describes who is behind the storage calls made with a context.
+type AuditMeta struct {
+	Actor     string
+	IP        string
+	UserAgent string
+}
+
+func WithAuditMeta(ctx context.Context, meta *AuditMeta) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, meta)
+}
+
+func AuditMetaFromContext(ctx context.Context) *AuditMeta {
+	if meta, ok := ctx.Value(auditContextKey{}).(*AuditMeta); ok && meta != nil {
+		return meta
+	}
+	return &AuditMeta{}
+}
+
+type balanceSnapshot struct {
+	Current   float32 `json:"current"`
+	Withdrawn float32 `json:"withdrawn"`
+	Held      float32 `json:"held"`
+	Debt      float32 `json:"debt"`
+}
+
+func getBalanceSnapshot(ctx context.Context, q pgxscan.Querier, userID int) (snapshot balanceSnapshot, err error) {
+	err = pgxscan.Get(ctx, q, &snapshot, "SELECT u.current, u.withdrawn, u.held, u.debt FROM public.user u WHERE u.id = $1 FOR UPDATE", userID)
+
+	return snapshot, err
+}
+
+// recordBalanceChange writes the balance snapshots taken before and after the
+// change together with the event details.
+func recordBalanceChange(ctx context.Context, tx pgx.Tx, eventType string, userID int, before balanceSnapshot, beforeDetails map[string]any, afterDetails map[string]any) (err error) {
+	after, err := getBalanceSnapshot(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	if beforeDetails == nil {
+		beforeDetails = map[string]any{}
+	}
+	if afterDetails == nil {
+		afterDetails = map[string]any{}
+	}
+	beforeDetails["balance"] = before
+	afterDetails["balance"] = after
+
+	return recordAuditEvent(ctx, tx, eventType, &userID, beforeDetails, afterDetails)
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+func (s DBStorage) RecordAuditEvent(ctx context.Context, eventType string, userID *int, before any, after any) (err error) {
+	return recordAuditEvent(ctx, s.pool, eventType, userID, before, after)
+}
+
+func (s DBStorage) RecordConfigReload(ctx context.Context, setting string, value string) (err error) {
+	return recordAuditEvent(ctx, s.pool, AuditConfigReloaded, nil, nil, map[string]string{setting: value})
+}
+
+func recordAuditEvent(ctx context.Context, db execer, eventType string, userID *int, before any, after any) (err error) {
+	meta := AuditMetaFromContext(ctx)
+	actor := meta.Actor
+	if actor == "" {
+		actor = auditSystemActor
+	}
+
+	beforeJSON, err := marshalAuditValue(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := marshalAuditValue(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "INSERT INTO public.audit_event (type, actor, user_id, ip, user_agent, before, after) VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7::jsonb)", eventType, actor, userID, meta.IP, meta.UserAgent, beforeJSON, afterJSON)
+
+	return err
+}
+
+func marshalAuditValue(value any) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	encoded := string(data)
+
+	return &encoded, nil
+}
+
+func (s DBStorage) GetAuditEvents(ctx context.Context, filter AuditFilter) (events []AuditEvent, next *Cursor, err error) {
+	qb := queryBuilder{}
+	if filter.UserID != nil {
+		qb.add("ae.user_id = ?", *filter.UserID)
+	}
+	if filter.Type != "" {
+		qb.add("ae.type = ?", filter.Type)
+	}
+	if !filter.From.IsZero() {
+		qb.add("ae.created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		qb.add("ae.created_at < ?", filter.To)
+	}
+	orderBy := qb.page("ae.created_at", "ae.id", filter.Page)
+
+	err = pgxscan.Select(ctx, s.pool, &events, "SELECT ae.id, ae.type, ae.actor, ae.user_id, ae.ip, ae.user_agent, ae.before, ae.after, ae.created_at FROM public.audit_event ae"+qb.whereClause()+orderBy, qb.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(events) == 0 {
+		return nil, nil, ErrNoAuditEventsFound
+	}
+
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+		last := events[len(events)-1]
+		next = &Cursor{At: last.CreatedAt, ID: last.ID}
+	}
+
+	return events, next, nil
+}
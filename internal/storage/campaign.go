@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	CampaignKindMultiplier = "multiplier"
+	CampaignKindFixed      = "fixed"
+)
+
+var (
+	ErrCampaignNotFound      = errors.New("campaign not found")
+	ErrCampaignInUse         = errors.New("campaign has already granted bonuses")
+	ErrWrongCampaignSettings = errors.New("wrong campaign settings")
+)
+
+const campaignColumns = "c.id, c.name, c.kind, c.value, c.starts_at, c.ends_at, c.first_order_only, c.min_accrual, c.budget, c.spent"
+
+func (c Campaign) Validate() error {
+	if strings.TrimSpace(c.Name) == "" || c.Value <= 0 || c.MinAccrual < 0 || !c.EndsAt.After(c.StartsAt) {
+		return ErrWrongCampaignSettings
+	}
+	if c.Budget != nil && *c.Budget < 0 {
+		return ErrWrongCampaignSettings
+	}
+	switch c.Kind {
+	case CampaignKindMultiplier:
+		if c.Value <= 1 {
+			return ErrWrongCampaignSettings
+		}
+	case CampaignKindFixed:
+	default:
+		return ErrWrongCampaignSettings
+	}
+
+	return nil
+}
+
+func (c Campaign) bonus(accrual float32) (bonus float32) {
+	if c.Kind == CampaignKindMultiplier {
+		bonus = accrual * (c.Value - 1)
+	} else {
+		bonus = c.Value
+	}
+	if c.Budget != nil && bonus > *c.Budget-c.Spent {
+		bonus = *c.Budget - c.Spent
+	}
+	if bonus < 0 {
+		bonus = 0
+	}
+
+	return bonus
+}
+
+func (s DBStorage) CreateCampaign(ctx context.Context, campaign Campaign) (Campaign, error) {
+	if err := campaign.Validate(); err != nil {
+		return campaign, err
+	}
+
+	err := s.pool.QueryRow(ctx, "INSERT INTO public.campaign (name, kind, value, starts_at, ends_at, first_order_only, min_accrual, budget) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, spent", campaign.Name, campaign.Kind, campaign.Value, campaign.StartsAt, campaign.EndsAt, campaign.FirstOrderOnly, campaign.MinAccrual, campaign.Budget).Scan(&campaign.ID, &campaign.Spent)
+
+	return campaign, err
+}
+
+func (s DBStorage) GetCampaigns(ctx context.Context) (campaigns []Campaign, err error) {
+	err = pgxscan.Select(ctx, s.pool, &campaigns, "SELECT "+campaignColumns+" FROM public.campaign c ORDER BY c.id")
+
+	return campaigns, err
+}
+
+func (s DBStorage) GetCampaign(ctx context.Context, id int) (campaign Campaign, err error) {
+	err = pgxscan.Get(ctx, s.pool, &campaign, "SELECT "+campaignColumns+" FROM public.campaign c WHERE c.id = $1", id)
+	if pgxscan.NotFound(err) {
+		return campaign, ErrCampaignNotFound
+	}
+
+	return campaign, err
+}
+
+func (s DBStorage) UpdateCampaign(ctx context.Context, campaign Campaign) (Campaign, error) {
+	if err := campaign.Validate(); err != nil {
+		return campaign, err
+	}
+
+	err := s.pool.QueryRow(ctx, "UPDATE public.campaign SET name = $1, kind = $2, value = $3, starts_at = $4, ends_at = $5, first_order_only = $6, min_accrual = $7, budget = $8 WHERE id = $9 RETURNING spent", campaign.Name, campaign.Kind, campaign.Value, campaign.StartsAt, campaign.EndsAt, campaign.FirstOrderOnly, campaign.MinAccrual, campaign.Budget, campaign.ID).Scan(&campaign.Spent)
+	if err == pgx.ErrNoRows {
+		return campaign, ErrCampaignNotFound
+	}
+
+	return campaign, err
+}
+
+func (s DBStorage) DeleteCampaign(ctx context.Context, id int) (err error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM public.campaign WHERE id = $1", id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+			return ErrCampaignInUse
+		}
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrCampaignNotFound
+	}
+
+	return nil
+}
+
+func (s DBStorage) applyCampaigns(ctx context.Context, tx pgx.Tx, order Order) (total float32, err error) {
+	var campaigns []Campaign
+	err = pgxscan.Select(ctx, tx, &campaigns, "SELECT "+campaignColumns+" FROM public.campaign c WHERE current_timestamp >= c.starts_at AND current_timestamp < c.ends_at AND c.min_accrual <= $1 AND (c.budget IS NULL OR c.spent < c.budget) ORDER BY c.id FOR UPDATE", order.Accrual)
+	if err != nil || len(campaigns) == 0 {
+		return 0, err
+	}
+
+	var firstOrder bool
+	err = tx.QueryRow(ctx, "SELECT NOT EXISTS (SELECT 1 FROM public.order o WHERE o.user_id = $1 AND o.status = 'PROCESSED' AND o.number <> $2)", order.UserID, order.Number).Scan(&firstOrder)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, campaign := range campaigns {
+		if campaign.FirstOrderOnly && !firstOrder {
+			continue
+		}
+		bonus := campaign.bonus(order.Accrual)
+		if bonus <= 0 {
+			continue
+		}
+
+		tag, err := tx.Exec(ctx, "INSERT INTO public.campaign_bonus (campaign_id, order_number, sum, user_id) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING", campaign.ID, order.Number, bonus, order.UserID)
+		if err != nil {
+			return 0, err
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE public.campaign SET spent = spent + $1 WHERE id = $2", bonus, campaign.ID)
+		if err != nil {
+			return 0, err
+		}
+		total += bonus
+	}
+
+	if total > 0 {
+		_, err = tx.Exec(ctx, "UPDATE public.order SET bonus = bonus + $1 WHERE number = $2", total, order.Number)
+	}
+
+	return total, err
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s DBStorage) ClawbackOrderAccrual(ctx context.Context, orderNumber string, sum float32, reason string) (clawback Clawback, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return clawback, err
+	}
+	defer tx.Rollback(ctx)
+
+	var order Order
+	err = tx.QueryRow(ctx, "SELECT o.status, o.accrual, o.tier_bonus, o.bonus, o.clawed_back, o.user_id FROM public.order o WHERE o.number = $1 FOR UPDATE", orderNumber).Scan(&order.Status, &order.Accrual, &order.TierBonus, &order.Bonus, &order.ClawedBack, &order.UserID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return clawback, ErrOrderNotFound
+		}
+		return clawback, err
+	}
+	if order.Status != "PROCESSED" {
+		return clawback, ErrOrderNotProcessed
+	}
+
+	remaining := order.Accrual + order.TierBonus + order.Bonus - order.ClawedBack
+	if sum == 0 {
+		sum = remaining
+	}
+	if sum <= 0 || sum > remaining {
+		return clawback, ErrClawbackExceedsAccrual
+	}
+
+	before, err := getBalanceSnapshot(ctx, tx, order.UserID)
+	if err != nil {
+		return clawback, err
+	}
+
+	clawback = Clawback{
+		OrderNumber: orderNumber,
+		Sum:         sum,
+		Recovered:   sum,
+		Reason:      reason,
+	}
+	if before.Current < sum {
+		clawback.Recovered = before.Current
+		clawback.Debt = sum - before.Current
+		// Held funds are not recovered now, but whatever comes back to the
+		// balance when a hold is released settles the debt. The partial
+		// policy only forgives what is not held.
+		if s.clawbackPolicy == ClawbackPolicyPartial && clawback.Debt > before.Held {
+			clawback.Debt = before.Held
+		}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.user SET current = current - $1, debt = debt + $2 WHERE id = $3", clawback.Recovered, clawback.Debt, order.UserID)
+	if err != nil {
+		return clawback, err
+	}
+
+	if err = s.consumeLots(ctx, tx, clawback.Recovered, order.UserID); err != nil {
+		return clawback, err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.order SET clawed_back = clawed_back + $1, status = CASE WHEN clawed_back + $1 >= accrual + tier_bonus + bonus THEN 'REVOKED' ELSE status END, status_changed_at = CASE WHEN clawed_back + $1 >= accrual + tier_bonus + bonus THEN current_timestamp ELSE status_changed_at END WHERE number = $2", sum, orderNumber)
+	if err != nil {
+		return clawback, err
+	}
+
+	err = tx.QueryRow(ctx, "INSERT INTO public.accrual_clawback (order_number, user_id, sum, recovered, debt, reason) VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at", orderNumber, order.UserID, clawback.Sum, clawback.Recovered, clawback.Debt, reason).Scan(&clawback.CreatedAt)
+	if err != nil {
+		return clawback, err
+	}
+
+	err = recordBalanceChange(ctx, tx, AuditClawback, order.UserID, before, nil, map[string]any{"order": orderNumber, "sum": sum, "recovered": clawback.Recovered, "debt": clawback.Debt, "reason": reason})
+	if err != nil {
+		return clawback, err
+	}
+
+	return clawback, tx.Commit(ctx)
+}
+
+// creditBalance adds sum to the balance of the user, whose row the caller has
+// locked. The outstanding debt is settled first, the rest is credited to the
+// current balance and returned.
+func creditBalance(ctx context.Context, tx pgx.Tx, userID int, sum float32) (credited float32, err error) {
+	err = tx.QueryRow(ctx, "UPDATE public.user u SET current = u.current + greatest($1 - prev.debt, 0), debt = greatest(prev.debt - $1, 0) FROM (SELECT p.debt FROM public.user p WHERE p.id = $2) prev WHERE u.id = $2 RETURNING greatest($1 - prev.debt, 0)", sum, userID).Scan(&credited)
+
+	return credited, err
+}
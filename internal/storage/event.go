@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+// Types of the user events, written by the triggers on public.order and
+// public.user.
+const (
+	EventOrderStatus = "order.status"
+	EventBalance     = "balance"
+)
+
+// userEventChannel is notified with the user id whenever an event is stored.
+const userEventChannel = "user_event"
+
+func (s DBStorage) GetUserEvents(ctx context.Context, userID int, afterID int64, limit int) (events []UserEvent, err error) {
+	err = pgxscan.Select(ctx, s.pool, &events, "SELECT e.id, e.type, e.data, e.created_at FROM public.user_event e WHERE e.user_id = $1 AND e.id > $2 ORDER BY e.id LIMIT $3", userID, afterID, limit)
+
+	return events, err
+}
+
+// GetLastUserEventID returns 0 when the user has no events yet.
+func (s DBStorage) GetLastUserEventID(ctx context.Context, userID int) (id int64, err error) {
+	err = s.pool.QueryRow(ctx, "SELECT coalesce(max(e.id), 0) FROM public.user_event e WHERE e.user_id = $1", userID).Scan(&id)
+
+	return id, err
+}
+
+func (s DBStorage) DeleteUserEvents(ctx context.Context, retention time.Duration) (deleted int64, err error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM public.user_event WHERE created_at < current_timestamp - make_interval(secs => $1)", retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// ListenUserEvents calls notify with the user id of every event committed by
+// any replica until ctx is done or the connection fails. The connection is
+// taken out of the pool, as it can not be reused while listening.
+func (s DBStorage) ListenUserEvents(ctx context.Context, notify func(userID int)) (err error) {
+	pooled, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err = conn.Exec(ctx, "LISTEN "+userEventChannel); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		userID, err := strconv.Atoi(notification.Payload)
+		if err != nil {
+			continue
+		}
+		notify(userID)
+	}
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func (s DBStorage) CreateHold(ctx context.Context, orderNumber string, sum float32, ttl time.Duration, userID int) (hold Hold, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return hold, err
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := getBalanceSnapshot(ctx, tx, userID)
+	if err != nil {
+		return hold, err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.user SET current = current - $1, held = held + $1 WHERE id = $2", sum, userID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.CheckViolation {
+			return hold, ErrInsufficientFunds
+		}
+		return hold, err
+	}
+
+	if err = s.consumeLots(ctx, tx, sum, userID); err != nil {
+		return hold, err
+	}
+
+	err = pgxscan.Get(ctx, tx, &hold, "INSERT INTO public.balance_hold (order_number, sum, expires_at, user_id) VALUES ($1, $2, current_timestamp + make_interval(secs => $3), $4) RETURNING id, order_number, sum, status, created_at, expires_at, resolved_at", orderNumber, sum, ttl.Seconds(), userID)
+	if err != nil {
+		return hold, err
+	}
+
+	if err = recordBalanceChange(ctx, tx, AuditHoldCreated, userID, before, nil, map[string]any{"order": orderNumber, "sum": sum, "hold": hold.ID}); err != nil {
+		return hold, err
+	}
+
+	return hold, tx.Commit(ctx)
+}
+
+func (s DBStorage) CaptureHold(ctx context.Context, holdID int, userID int) (hold Hold, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return hold, err
+	}
+	defer tx.Rollback(ctx)
+
+	hold, err = s.lockActiveHold(ctx, tx, holdID, userID)
+	if err != nil {
+		return hold, err
+	}
+
+	before, err := getBalanceSnapshot(ctx, tx, userID)
+	if err != nil {
+		return hold, err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.user SET held = greatest(held - $1, 0), withdrawn = withdrawn + $1 WHERE id = $2", hold.Sum, userID)
+	if err != nil {
+		return hold, err
+	}
+
+	if err = s.insertWithdrawal(ctx, tx, hold.OrderNumber, hold.Sum, userID); err != nil {
+		return hold, err
+	}
+
+	if err = recordBalanceChange(ctx, tx, AuditWithdrawal, userID, before, nil, map[string]any{"order": hold.OrderNumber, "sum": hold.Sum, "hold": holdID}); err != nil {
+		return hold, err
+	}
+
+	err = tx.QueryRow(ctx, "UPDATE public.balance_hold SET status = 'CAPTURED', resolved_at = current_timestamp WHERE id = $1 RETURNING status, resolved_at", holdID).Scan(&hold.Status, &hold.ResolvedAt)
+	if err != nil {
+		return hold, err
+	}
+
+	return hold, tx.Commit(ctx)
+}
+
+func (s DBStorage) ReleaseHold(ctx context.Context, holdID int, userID int) (hold Hold, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return hold, err
+	}
+	defer tx.Rollback(ctx)
+
+	hold, err = s.lockActiveHold(ctx, tx, holdID, userID)
+	if err != nil {
+		return hold, err
+	}
+
+	before, err := getBalanceSnapshot(ctx, tx, userID)
+	if err != nil {
+		return hold, err
+	}
+
+	credited, err := s.releaseHeld(ctx, tx, hold.Sum, userID)
+	if err != nil {
+		return hold, err
+	}
+
+	if err = s.restoreLots(ctx, tx, credited, userID); err != nil {
+		return hold, err
+	}
+
+	if err = recordBalanceChange(ctx, tx, AuditHoldReleased, userID, before, nil, map[string]any{"order": hold.OrderNumber, "sum": hold.Sum, "hold": holdID}); err != nil {
+		return hold, err
+	}
+
+	err = tx.QueryRow(ctx, "UPDATE public.balance_hold SET status = 'RELEASED', resolved_at = current_timestamp WHERE id = $1 RETURNING status, resolved_at", holdID).Scan(&hold.Status, &hold.ResolvedAt)
+	if err != nil {
+		return hold, err
+	}
+
+	return hold, tx.Commit(ctx)
+}
+
+func (s DBStorage) lockActiveHold(ctx context.Context, tx pgx.Tx, holdID int, userID int) (hold Hold, err error) {
+	var expired bool
+	err = tx.QueryRow(ctx, "SELECT h.id, h.order_number, h.sum, h.status, h.created_at, h.expires_at, h.expires_at <= current_timestamp FROM public.balance_hold h WHERE h.id = $1 AND h.user_id = $2 FOR UPDATE", holdID, userID).Scan(&hold.ID, &hold.OrderNumber, &hold.Sum, &hold.Status, &hold.CreatedAt, &hold.ExpiresAt, &expired)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return hold, ErrHoldNotFound
+		}
+		return hold, err
+	}
+	if hold.Status != "HELD" || expired {
+		return hold, ErrHoldNotActive
+	}
+
+	return hold, nil
+}
+
+func (s DBStorage) ReleaseExpiredHolds(ctx context.Context) (released int, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, "UPDATE public.balance_hold SET status = 'EXPIRED', resolved_at = current_timestamp WHERE id IN (SELECT h.id FROM public.balance_hold h WHERE h.status = 'HELD' AND h.expires_at <= current_timestamp ORDER BY h.id FOR UPDATE SKIP LOCKED) RETURNING user_id, sum")
+	if err != nil {
+		return 0, err
+	}
+	refunds := make(map[int]float32)
+	for rows.Next() {
+		var userID int
+		var sum float32
+		if err = rows.Scan(&userID, &sum); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		refunds[userID] += sum
+		released++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	userIDs := make([]int, 0, len(refunds))
+	for userID := range refunds {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Ints(userIDs)
+	for _, userID := range userIDs {
+		before, err := getBalanceSnapshot(ctx, tx, userID)
+		if err != nil {
+			return 0, err
+		}
+
+		credited, err := s.releaseHeld(ctx, tx, refunds[userID], userID)
+		if err != nil {
+			return 0, err
+		}
+
+		if err = s.restoreLots(ctx, tx, credited, userID); err != nil {
+			return 0, err
+		}
+
+		if err = recordBalanceChange(ctx, tx, AuditHoldReleased, userID, before, nil, map[string]any{"sum": refunds[userID], "expired": true}); err != nil {
+			return 0, err
+		}
+	}
+
+	return released, tx.Commit(ctx)
+}
+
+// releaseHeld moves sum from the held funds of the user back to the balance,
+// settling the outstanding debt first.
+func (s DBStorage) releaseHeld(ctx context.Context, tx pgx.Tx, sum float32, userID int) (credited float32, err error) {
+	_, err = tx.Exec(ctx, "UPDATE public.user SET held = greatest(held - $1, 0) WHERE id = $2", sum, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return creditBalance(ctx, tx, userID, sum)
+}
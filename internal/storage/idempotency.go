@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s DBStorage) ReserveIdempotencyKey(ctx context.Context, key string, requestHash string, userID int, ttl time.Duration) (response *IdempotentResponse, err error) {
+	var reserved bool
+	err = s.pool.QueryRow(ctx, "INSERT INTO public.idempotency_key (key, user_id, request_hash, expires_at) VALUES ($1, $2, $3, current_timestamp + make_interval(secs => $4)) ON CONFLICT (user_id, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, response = NULL, expires_at = EXCLUDED.expires_at WHERE idempotency_key.expires_at < current_timestamp RETURNING true", key, userID, requestHash, ttl.Seconds()).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if err != pgx.ErrNoRows {
+		return nil, err
+	}
+
+	var storedHash string
+	var statusCode *int
+	response = &IdempotentResponse{}
+	err = s.pool.QueryRow(ctx, "SELECT ik.request_hash, ik.status_code, coalesce(ik.content_type, ''), coalesce(ik.response, ''::bytea) FROM public.idempotency_key ik WHERE ik.user_id = $1 AND ik.key = $2", userID, key).Scan(&storedHash, &statusCode, &response.ContentType, &response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if storedHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if statusCode == nil {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	response.StatusCode = *statusCode
+
+	return response, nil
+}
+
+func (s DBStorage) SaveIdempotentResponse(ctx context.Context, key string, userID int, response IdempotentResponse) (err error) {
+	_, err = s.pool.Exec(ctx, "UPDATE public.idempotency_key SET status_code = $1, content_type = $2, response = $3 WHERE user_id = $4 AND key = $5", response.StatusCode, response.ContentType, response.Body, userID, key)
+
+	return err
+}
+
+func (s DBStorage) ReleaseIdempotencyKey(ctx context.Context, key string, userID int) (err error) {
+	_, err = s.pool.Exec(ctx, "DELETE FROM public.idempotency_key WHERE user_id = $1 AND key = $2 AND status_code IS NULL", userID, key)
+
+	return err
+}
+
+func (s DBStorage) DeleteExpiredIdempotencyKeys(ctx context.Context) (deleted int64, err error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM public.idempotency_key WHERE expires_at < current_timestamp")
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+type LockoutPolicy struct {
+	MaxFailures   int
+	IPMaxFailures int
+	Delay         time.Duration
+	Lockout       time.Duration
+	Window        time.Duration
+}
+
+func (s *DBStorage) SetLockoutPolicy(policy LockoutPolicy) {
+	s.lockoutPolicy = policy
+}
+
+func (p LockoutPolicy) enabled() bool {
+	return p.MaxFailures > 0 || p.IPMaxFailures > 0 || p.Delay > 0
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// lockFor returns how long a key stays locked after the given number of
+// consecutive failures: the delay doubles with each failure and turns into a
+// full lockout once the threshold is reached.
+func (p LockoutPolicy) lockFor(failures int, threshold int) time.Duration {
+	if threshold > 0 && failures >= threshold {
+		return p.Lockout
+	}
+	if p.Delay <= 0 || failures < 2 {
+		return 0
+	}
+
+	delay := p.Delay * time.Duration(math.Pow(2, float64(failures-2)))
+	if p.Lockout > 0 && delay > p.Lockout {
+		delay = p.Lockout
+	}
+
+	return delay
+}
+
+// GetLoginLock also returns the failures recorded for the login, so that the
+// caller resets them only when there is something to reset.
+func (s DBStorage) GetLoginLock(ctx context.Context, login string, ip string) (retryAfter time.Duration, failures int, err error) {
+	if !s.lockoutPolicy.enabled() {
+		return 0, 0, nil
+	}
+
+	var seconds float64
+	err = s.pool.QueryRow(ctx, "SELECT coalesce(extract(epoch FROM max(lf.locked_until) FILTER (WHERE lf.locked_until > current_timestamp) - current_timestamp), 0)::float8, coalesce(max(lf.failures) FILTER (WHERE lf.key = $2), 0) FROM public.login_failure lf WHERE lf.key = ANY($1)", []string{loginKey(login), ipKey(ip)}, loginKey(login)).Scan(&seconds, &failures)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return time.Duration(seconds * float64(time.Second)), failures, nil
+}
+
+func (s DBStorage) RegisterLoginFailure(ctx context.Context, login string, ip string) (failures int, retryAfter time.Duration, err error) {
+	if !s.lockoutPolicy.enabled() {
+		return 0, 0, nil
+	}
+
+	failures, err = s.registerFailure(ctx, loginKey(login))
+	if err != nil {
+		return 0, 0, err
+	}
+	retryAfter = s.lockoutPolicy.lockFor(failures, s.lockoutPolicy.MaxFailures)
+	if err = s.lockKey(ctx, loginKey(login), retryAfter); err != nil {
+		return failures, retryAfter, err
+	}
+
+	ipFailures, err := s.registerFailure(ctx, ipKey(ip))
+	if err != nil {
+		return failures, retryAfter, err
+	}
+	if s.lockoutPolicy.IPMaxFailures > 0 && ipFailures >= s.lockoutPolicy.IPMaxFailures {
+		if err = s.lockKey(ctx, ipKey(ip), s.lockoutPolicy.Lockout); err != nil {
+			return failures, retryAfter, err
+		}
+		if s.lockoutPolicy.Lockout > retryAfter {
+			retryAfter = s.lockoutPolicy.Lockout
+		}
+	}
+
+	return failures, retryAfter, nil
+}
+
+func (s DBStorage) registerFailure(ctx context.Context, key string) (failures int, err error) {
+	err = s.pool.QueryRow(ctx, "INSERT INTO public.login_failure AS lf (key, failures, last_failure_at) VALUES ($1, 1, current_timestamp) ON CONFLICT (key) DO UPDATE SET failures = CASE WHEN lf.last_failure_at < current_timestamp - make_interval(secs => $2) THEN 1 ELSE lf.failures + 1 END, last_failure_at = current_timestamp RETURNING lf.failures", key, s.lockoutPolicy.Window.Seconds()).Scan(&failures)
+
+	return failures, err
+}
+
+func (s DBStorage) lockKey(ctx context.Context, key string, lock time.Duration) (err error) {
+	if lock <= 0 {
+		return nil
+	}
+	_, err = s.pool.Exec(ctx, "UPDATE public.login_failure SET locked_until = current_timestamp + make_interval(secs => $1) WHERE key = $2", lock.Seconds(), key)
+
+	return err
+}
+
+func (s DBStorage) ResetLoginFailures(ctx context.Context, login string) (err error) {
+	if !s.lockoutPolicy.enabled() {
+		return nil
+	}
+
+	_, err = s.pool.Exec(ctx, "DELETE FROM public.login_failure WHERE key = $1", loginKey(login))
+
+	return err
+}
+
+// DeleteExpiredLoginFailures removes the failures which no longer count
+// towards a lockout: the window has passed and the key is not locked.
+func (s DBStorage) DeleteExpiredLoginFailures(ctx context.Context) (deleted int64, err error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM public.login_failure WHERE last_failure_at < current_timestamp - make_interval(secs => $1) AND (locked_until IS NULL OR locked_until <= current_timestamp)", s.lockoutPolicy.Window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
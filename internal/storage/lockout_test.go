@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutPolicy_lockFor(t *testing.T) {
+	policy := LockoutPolicy{MaxFailures: 5, Delay: time.Second, Lockout: 15 * time.Minute}
+
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{
+			name:     "Positive_FirstFailure",
+			failures: 1,
+			want:     0,
+		},
+		{
+			name:     "Positive_ProgressiveDelay",
+			failures: 2,
+			want:     time.Second,
+		},
+		{
+			name:     "Positive_DoubledDelay",
+			failures: 4,
+			want:     4 * time.Second,
+		},
+		{
+			name:     "Positive_Lockout",
+			failures: 5,
+			want:     15 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, policy.lockFor(tt.failures, policy.MaxFailures))
+		})
+	}
+}
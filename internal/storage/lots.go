@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+type ExpirationPolicy struct {
+	Months int
+	Notice time.Duration
+}
+
+// SetExpirationPolicy also backfills a lot for every balance accrued before the
+// lots were tracked, as only now the lifetime of the points is known.
+func (s *DBStorage) SetExpirationPolicy(ctx context.Context, policy ExpirationPolicy) (err error) {
+	s.expirationPolicy = policy
+	if !s.expirationEnabled() {
+		return nil
+	}
+
+	_, err = s.pool.Exec(ctx, "INSERT INTO public.accrual_lot (amount, remaining, expires_at, user_id) SELECT u.current, u.current, current_timestamp + make_interval(months => $1), u.id FROM public.user u WHERE u.current > 0 AND NOT EXISTS (SELECT 1 FROM public.accrual_lot l WHERE l.user_id = u.id)", policy.Months)
+
+	return err
+}
+
+func (s DBStorage) expirationEnabled() bool {
+	return s.expirationPolicy.Months > 0
+}
+
+func (s DBStorage) createLot(ctx context.Context, tx pgx.Tx, orderNumber string, sum float32, userID int) (err error) {
+	if !s.expirationEnabled() || sum <= 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO public.accrual_lot (order_number, amount, remaining, expires_at, user_id) VALUES ($1, $2, $2, current_timestamp + make_interval(months => $3), $4)", orderNumber, sum, s.expirationPolicy.Months, userID)
+
+	return err
+}
+
+func (s DBStorage) consumeLots(ctx context.Context, tx pgx.Tx, sum float32, userID int) (err error) {
+	if !s.expirationEnabled() || sum <= 0 {
+		return nil
+	}
+
+	var lots []AccrualLot
+	err = pgxscan.Select(ctx, tx, &lots, "SELECT l.id, l.remaining FROM public.accrual_lot l WHERE l.user_id = $1 AND l.remaining > 0 AND l.expires_at > current_timestamp ORDER BY l.expires_at, l.id FOR UPDATE", userID)
+	if err != nil {
+		return err
+	}
+
+	for _, lot := range lots {
+		if sum <= 0 {
+			break
+		}
+		consumed := lot.Remaining
+		if sum < consumed {
+			consumed = sum
+		}
+		_, err = tx.Exec(ctx, "UPDATE public.accrual_lot SET remaining = greatest(remaining - $1, 0) WHERE id = $2", consumed, lot.ID)
+		if err != nil {
+			return err
+		}
+		sum -= consumed
+	}
+
+	return nil
+}
+
+func (s DBStorage) restoreLots(ctx context.Context, tx pgx.Tx, sum float32, userID int) (err error) {
+	if !s.expirationEnabled() || sum <= 0 {
+		return nil
+	}
+
+	var lots []AccrualLot
+	err = pgxscan.Select(ctx, tx, &lots, "SELECT l.id, l.amount, l.remaining FROM public.accrual_lot l WHERE l.user_id = $1 AND l.remaining < l.amount AND l.expires_at > current_timestamp ORDER BY l.expires_at DESC, l.id DESC FOR UPDATE", userID)
+	if err != nil {
+		return err
+	}
+
+	for _, lot := range lots {
+		if sum <= 0 {
+			break
+		}
+		restored := lot.Amount - lot.Remaining
+		if sum < restored {
+			restored = sum
+		}
+		_, err = tx.Exec(ctx, "UPDATE public.accrual_lot SET remaining = least(remaining + $1, amount) WHERE id = $2", restored, lot.ID)
+		if err != nil {
+			return err
+		}
+		sum -= restored
+	}
+
+	return s.createLot(ctx, tx, "", sum, userID)
+}
+
+// ExpirePoints commits per user, locking the user before the lots like every
+// other balance change does.
+func (s DBStorage) ExpirePoints(ctx context.Context) (expired int, err error) {
+	var userIDs []int
+	err = pgxscan.Select(ctx, s.pool, &userIDs, "SELECT DISTINCT l.user_id FROM public.accrual_lot l WHERE l.remaining > 0 AND l.expires_at <= current_timestamp ORDER BY l.user_id")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, userID := range userIDs {
+		n, err := s.expireUserPoints(ctx, userID)
+		if err != nil {
+			return expired, err
+		}
+		expired += n
+	}
+
+	return expired, nil
+}
+
+func (s DBStorage) expireUserPoints(ctx context.Context, userID int) (expired int, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := getBalanceSnapshot(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	current := before.Current
+
+	var lots []AccrualLot
+	err = pgxscan.Select(ctx, tx, &lots, "SELECT l.id, l.remaining FROM public.accrual_lot l WHERE l.user_id = $1 AND l.remaining > 0 AND l.expires_at <= current_timestamp ORDER BY l.id FOR UPDATE", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var total float32
+	for _, lot := range lots {
+		sum := lot.Remaining
+		if current < sum {
+			sum = current
+		}
+		current -= sum
+
+		_, err = tx.Exec(ctx, "UPDATE public.user SET current = greatest(current - $1, 0) WHERE id = $2", sum, userID)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE public.accrual_lot SET remaining = 0 WHERE id = $1", lot.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.Exec(ctx, "INSERT INTO public.point_expiration (lot_id, sum, user_id) VALUES ($1, $2, $3)", lot.ID, sum, userID)
+		if err != nil {
+			return 0, err
+		}
+		total += sum
+		expired++
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+
+	if err = recordBalanceChange(ctx, tx, AuditPointsExpired, userID, before, nil, map[string]any{"lots": expired, "sum": total}); err != nil {
+		return 0, err
+	}
+
+	return expired, tx.Commit(ctx)
+}
+
+func (s DBStorage) getUpcomingExpirations(ctx context.Context, userID int) (expirations []Expiration, err error) {
+	if !s.expirationEnabled() {
+		return nil, nil
+	}
+
+	err = pgxscan.Select(ctx, s.pool, &expirations, "SELECT date_trunc('day', l.expires_at) AS expires_at, sum(l.remaining) AS sum FROM public.accrual_lot l WHERE l.user_id = $1 AND l.remaining > 0 AND l.expires_at > current_timestamp AND l.expires_at <= current_timestamp + make_interval(secs => $2) GROUP BY 1 ORDER BY 1", userID, s.expirationPolicy.Notice.Seconds())
+
+	return expirations, err
+}
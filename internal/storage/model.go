@@ -6,9 +6,13 @@ import (
 )
 
 type Order struct {
+	ID         int       `json:"-"`
 	Number     string    `json:"number"`
 	Status     string    `json:"status"`
 	Accrual    float32   `json:"accrual,omitempty"`
+	ClawedBack float32   `json:"clawed_back,omitempty" db:"clawed_back"`
+	TierBonus  float32   `json:"tier_bonus,omitempty" db:"tier_bonus"`
+	Bonus      float32   `json:"bonus,omitempty"`
 	UploadedAt time.Time `json:"-" db:"uploaded_at"`
 	UserID     int       `json:"-"  db:"user_id"`
 }
@@ -27,10 +31,46 @@ func (o Order) MarshalJSON() ([]byte, error) {
 	return json.Marshal(aliasValue)
 }
 
+type OrderDetails struct {
+	Order
+	Attempts        int       `json:"attempts"`
+	StatusChangedAt time.Time `json:"-" db:"status_changed_at"`
+}
+
+func (o OrderDetails) MarshalJSON() ([]byte, error) {
+	aliasValue := struct {
+		Number            string  `json:"number"`
+		Status            string  `json:"status"`
+		Accrual           float32 `json:"accrual,omitempty"`
+		ClawedBack        float32 `json:"clawed_back,omitempty"`
+		TierBonus         float32 `json:"tier_bonus,omitempty"`
+		Bonus             float32 `json:"bonus,omitempty"`
+		UploadedAtRFC3339 string  `json:"uploaded_at"`
+		Attempts          int     `json:"attempts"`
+		StatusChangedAt   string  `json:"status_changed_at"`
+	}{
+		Number:            o.Number,
+		Status:            o.Status,
+		Accrual:           o.Accrual,
+		ClawedBack:        o.ClawedBack,
+		TierBonus:         o.TierBonus,
+		Bonus:             o.Bonus,
+		UploadedAtRFC3339: o.UploadedAt.Format(time.RFC3339),
+		Attempts:          o.Attempts,
+		StatusChangedAt:   o.StatusChangedAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
 type Withdrawal struct {
-	OrderNumber string    `json:"order" db:"order_number"`
-	Sum         float32   `json:"sum"`
-	ProcessedAt time.Time `json:"-" db:"processed_at"`
+	ID             int        `json:"-"`
+	OrderNumber    string     `json:"order" db:"order_number"`
+	Sum            float32    `json:"sum"`
+	ProcessedAt    time.Time  `json:"-" db:"processed_at"`
+	ReversedAt     *time.Time `json:"-" db:"reversed_at"`
+	ReversalReason *string    `json:"reversal_reason,omitempty" db:"reversal_reason"`
+	ReversalOf     *int       `json:"-" db:"reversal_of"`
 }
 
 func (w Withdrawal) MarshalJSON() ([]byte, error) {
@@ -39,10 +79,302 @@ func (w Withdrawal) MarshalJSON() ([]byte, error) {
 	aliasValue := struct {
 		WithdrawalAlias
 		ProcessedAtRFC3339 string `json:"processed_at"`
+		ReversedAtRFC3339  string `json:"reversed_at,omitempty"`
+		Reversal           bool   `json:"reversal,omitempty"`
 	}{
 		WithdrawalAlias:    WithdrawalAlias(w),
 		ProcessedAtRFC3339: w.ProcessedAt.Format(time.RFC3339),
+		Reversal:           w.ReversalOf != nil,
+	}
+	if w.ReversedAt != nil {
+		aliasValue.ReversedAtRFC3339 = w.ReversedAt.Format(time.RFC3339)
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+type WithdrawalSummary struct {
+	Count int     `json:"count"`
+	Total float32 `json:"total"`
+}
+
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+type Balance struct {
+	Current   float32      `json:"current"`
+	Withdrawn float32      `json:"withdrawn"`
+	Held      float32      `json:"held"`
+	Debt      float32      `json:"debt,omitempty"`
+	Expiring  []Expiration `json:"expiring,omitempty"`
+}
+
+type AccrualLot struct {
+	ID        int
+	Amount    float32
+	Remaining float32
+	UserID    int `db:"user_id"`
+}
+
+type Expiration struct {
+	Sum       float32   `json:"sum"`
+	ExpiresAt time.Time `json:"-" db:"expires_at"`
+}
+
+func (e Expiration) MarshalJSON() ([]byte, error) {
+	type ExpirationAlias Expiration
+
+	aliasValue := struct {
+		ExpirationAlias
+		ExpiresAtRFC3339 string `json:"expires_at"`
+	}{
+		ExpirationAlias:  ExpirationAlias(e),
+		ExpiresAtRFC3339: e.ExpiresAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+type Clawback struct {
+	OrderNumber string    `json:"order"`
+	Sum         float32   `json:"sum"`
+	Recovered   float32   `json:"recovered"`
+	Debt        float32   `json:"debt"`
+	Reason      string    `json:"reason"`
+	CreatedAt   time.Time `json:"-"`
+}
+
+func (cb Clawback) MarshalJSON() ([]byte, error) {
+	type ClawbackAlias Clawback
+
+	aliasValue := struct {
+		ClawbackAlias
+		CreatedAtRFC3339 string `json:"created_at"`
+	}{
+		ClawbackAlias:    ClawbackAlias(cb),
+		CreatedAtRFC3339: cb.CreatedAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+type Hold struct {
+	ID          int        `json:"id"`
+	OrderNumber string     `json:"order" db:"order_number"`
+	Sum         float32    `json:"sum"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"-" db:"created_at"`
+	ExpiresAt   time.Time  `json:"-" db:"expires_at"`
+	ResolvedAt  *time.Time `json:"-" db:"resolved_at"`
+}
+
+func (h Hold) MarshalJSON() ([]byte, error) {
+	type HoldAlias Hold
+
+	aliasValue := struct {
+		HoldAlias
+		CreatedAtRFC3339  string `json:"created_at"`
+		ExpiresAtRFC3339  string `json:"expires_at"`
+		ResolvedAtRFC3339 string `json:"resolved_at,omitempty"`
+	}{
+		HoldAlias:        HoldAlias(h),
+		CreatedAtRFC3339: h.CreatedAt.Format(time.RFC3339),
+		ExpiresAtRFC3339: h.ExpiresAt.Format(time.RFC3339),
+	}
+	if h.ResolvedAt != nil {
+		aliasValue.ResolvedAtRFC3339 = h.ResolvedAt.Format(time.RFC3339)
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+type TierProgress struct {
+	Tier      Tier    `json:"tier"`
+	Basis     string  `json:"basis"`
+	Points    float32 `json:"points"`
+	Next      *Tier   `json:"next,omitempty"`
+	Remaining float32 `json:"remaining,omitempty"`
+}
+
+type Campaign struct {
+	ID             int       `json:"id"`
+	Name           string    `json:"name"`
+	Kind           string    `json:"kind"`
+	Value          float32   `json:"value"`
+	StartsAt       time.Time `json:"-" db:"starts_at"`
+	EndsAt         time.Time `json:"-" db:"ends_at"`
+	FirstOrderOnly bool      `json:"first_order_only" db:"first_order_only"`
+	MinAccrual     float32   `json:"min_accrual" db:"min_accrual"`
+	Budget         *float32  `json:"budget,omitempty"`
+	Spent          float32   `json:"spent"`
+}
+
+func (c Campaign) MarshalJSON() ([]byte, error) {
+	type CampaignAlias Campaign
+
+	aliasValue := struct {
+		CampaignAlias
+		StartsAtRFC3339 string `json:"starts_at"`
+		EndsAtRFC3339   string `json:"ends_at"`
+	}{
+		CampaignAlias:   CampaignAlias(c),
+		StartsAtRFC3339: c.StartsAt.Format(time.RFC3339),
+		EndsAtRFC3339:   c.EndsAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+type Referral struct {
+	Login      string     `json:"login"`
+	Status     string     `json:"status"`
+	Bonus      float32    `json:"bonus" db:"referrer_bonus"`
+	CreatedAt  time.Time  `json:"-" db:"created_at"`
+	ResolvedAt *time.Time `json:"-" db:"resolved_at"`
+}
+
+func (r Referral) MarshalJSON() ([]byte, error) {
+	type ReferralAlias Referral
+
+	aliasValue := struct {
+		ReferralAlias
+		CreatedAtRFC3339  string `json:"created_at"`
+		ResolvedAtRFC3339 string `json:"resolved_at,omitempty"`
+	}{
+		ReferralAlias:    ReferralAlias(r),
+		CreatedAtRFC3339: r.CreatedAt.Format(time.RFC3339),
+	}
+	if r.ResolvedAt != nil {
+		aliasValue.ResolvedAtRFC3339 = r.ResolvedAt.Format(time.RFC3339)
 	}
 
 	return json.Marshal(aliasValue)
 }
+
+type UserReferrals struct {
+	Code      string     `json:"code"`
+	Referrals []Referral `json:"referrals"`
+}
+
+type Transfer struct {
+	ID           int       `json:"-"`
+	Direction    string    `json:"direction"`
+	Counterparty string    `json:"counterparty"`
+	Sum          float32   `json:"sum"`
+	CreatedAt    time.Time `json:"-" db:"created_at"`
+}
+
+func (t Transfer) MarshalJSON() ([]byte, error) {
+	type TransferAlias Transfer
+
+	aliasValue := struct {
+		TransferAlias
+		CreatedAtRFC3339 string `json:"created_at"`
+	}{
+		TransferAlias:    TransferAlias(t),
+		CreatedAtRFC3339: t.CreatedAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+type UserSummary struct {
+	ID        int     `json:"id"`
+	Login     string  `json:"login"`
+	Role      string  `json:"role"`
+	Tier      string  `json:"tier,omitempty"`
+	Current   float32 `json:"current"`
+	Withdrawn float32 `json:"withdrawn"`
+	Held      float32 `json:"held"`
+	Debt      float32 `json:"debt,omitempty"`
+}
+
+type BalanceAdjustment struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	Sum       float32   `json:"sum"`
+	Reason    string    `json:"reason"`
+	Operator  string    `json:"operator"`
+	CreatedAt time.Time `json:"-" db:"created_at"`
+}
+
+func (a BalanceAdjustment) MarshalJSON() ([]byte, error) {
+	type BalanceAdjustmentAlias BalanceAdjustment
+
+	aliasValue := struct {
+		BalanceAdjustmentAlias
+		CreatedAtRFC3339 string `json:"created_at"`
+	}{
+		BalanceAdjustmentAlias: BalanceAdjustmentAlias(a),
+		CreatedAtRFC3339:       a.CreatedAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+type AuditEvent struct {
+	ID        int             `json:"id"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor"`
+	UserID    *int            `json:"user_id,omitempty" db:"user_id"`
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty" db:"user_agent"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt time.Time       `json:"-" db:"created_at"`
+}
+
+func (e AuditEvent) MarshalJSON() ([]byte, error) {
+	type AuditEventAlias AuditEvent
+
+	aliasValue := struct {
+		AuditEventAlias
+		CreatedAtRFC3339 string `json:"created_at"`
+	}{
+		AuditEventAlias:  AuditEventAlias(e),
+		CreatedAtRFC3339: e.CreatedAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"-" db:"created_at"`
+	LastUsedAt *time.Time `json:"-" db:"last_used_at"`
+	// Token is only filled in when the key is created.
+	Token string `json:"token,omitempty" db:"-"`
+}
+
+func (k APIKey) MarshalJSON() ([]byte, error) {
+	type APIKeyAlias APIKey
+
+	aliasValue := struct {
+		APIKeyAlias
+		CreatedAtRFC3339  string `json:"created_at"`
+		LastUsedAtRFC3339 string `json:"last_used_at,omitempty"`
+	}{
+		APIKeyAlias:      APIKeyAlias(k),
+		CreatedAtRFC3339: k.CreatedAt.Format(time.RFC3339),
+	}
+	if k.LastUsedAt != nil {
+		aliasValue.LastUsedAtRFC3339 = k.LastUsedAt.Format(time.RFC3339)
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+// UserEvent is a change pushed to the user event stream. Data is the JSON
+// object sent to the client as is.
+type UserEvent struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"-" db:"created_at"`
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+type Cursor struct {
+	At time.Time
+	ID int
+}
+
+func (c Cursor) String() string {
+	raw := strconv.FormatInt(c.At.UnixNano(), 10) + ":" + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func ParseCursor(s string) (cursor Cursor, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	at, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return cursor, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	cursor.ID, err = strconv.Atoi(id)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	cursor.At = time.Unix(0, nanos)
+
+	return cursor, nil
+}
+
+type Page struct {
+	Limit int
+	After *Cursor
+	Desc  bool
+}
+
+type OrderFilter struct {
+	Page
+	Statuses     []string
+	UploadedFrom time.Time
+	UploadedTo   time.Time
+}
+
+type WithdrawalFilter struct {
+	Page
+	ProcessedFrom time.Time
+	ProcessedTo   time.Time
+	MinSum        *float32
+	MaxSum        *float32
+}
+
+func (f WithdrawalFilter) apply(qb *queryBuilder) {
+	if !f.ProcessedFrom.IsZero() {
+		qb.add("wd.processed_at >= ?", f.ProcessedFrom)
+	}
+	if !f.ProcessedTo.IsZero() {
+		qb.add("wd.processed_at < ?", f.ProcessedTo)
+	}
+	if f.MinSum != nil {
+		qb.add("wd.sum >= ?", *f.MinSum)
+	}
+	if f.MaxSum != nil {
+		qb.add("wd.sum <= ?", *f.MaxSum)
+	}
+}
+
+type queryBuilder struct {
+	where []string
+	args  []any
+}
+
+func (qb *queryBuilder) add(condition string, args ...any) {
+	for _, arg := range args {
+		qb.args = append(qb.args, arg)
+		condition = strings.Replace(condition, "?", "$"+strconv.Itoa(len(qb.args)), 1)
+	}
+	qb.where = append(qb.where, condition)
+}
+
+func (qb *queryBuilder) page(timeColumn string, idColumn string, page Page) (orderBy string) {
+	direction, compare := "ASC", ">"
+	if page.Desc {
+		direction, compare = "DESC", "<"
+	}
+	if page.After != nil {
+		qb.add("("+timeColumn+", "+idColumn+") "+compare+" (?, ?)", page.After.At, page.After.ID)
+	}
+
+	orderBy = " ORDER BY " + timeColumn + " " + direction + ", " + idColumn + " " + direction
+	if page.Limit > 0 {
+		qb.args = append(qb.args, page.Limit+1)
+		orderBy += " LIMIT $" + strconv.Itoa(len(qb.args))
+	}
+
+	return orderBy
+}
+
+func (qb *queryBuilder) whereClause() string {
+	if len(qb.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(qb.where, " AND ")
+}
+
+type AuditFilter struct {
+	Page
+	UserID *int
+	Type   string
+	From   time.Time
+	To     time.Time
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCursor(t *testing.T) {
+	cursor := Cursor{At: time.Date(2023, 3, 1, 12, 30, 0, 123000, time.UTC), ID: 42}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		want    Cursor
+		errType error
+	}{
+		{
+			name:    "Positive_RoundTrip",
+			cursor:  cursor.String(),
+			want:    cursor,
+			errType: nil,
+		},
+		{
+			name:    "Negative_NotBase64",
+			cursor:  "not a cursor",
+			errType: ErrInvalidCursor,
+		},
+		{
+			name:    "Negative_WrongFormat",
+			cursor:  "MTIz",
+			errType: ErrInvalidCursor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCursor(tt.cursor)
+			assert.Equal(t, tt.errType, err)
+			assert.True(t, tt.want.At.Equal(got.At))
+			assert.Equal(t, tt.want.ID, got.ID)
+		})
+	}
+}
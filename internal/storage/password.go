@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	AuditPasswordChanged        = "password.changed"
+	AuditPasswordResetRequested = "password.reset_requested"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreatePasswordResetToken issues a single-use token. Only its hash is stored,
+// the token itself has to be delivered to the user. Unknown logins go through
+// the same statements, so that the response time does not tell them apart.
+func (s DBStorage) CreatePasswordResetToken(ctx context.Context, login string, ttl time.Duration) (token string, expiresAt time.Time, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", expiresAt, err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return "", expiresAt, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID *int
+	err = tx.QueryRow(ctx, "WITH issued AS (INSERT INTO public.password_reset_token (token_hash, user_id, expires_at) SELECT $1, u.id, current_timestamp + make_interval(secs => $3) FROM public.user u WHERE u.login = $2 RETURNING user_id, expires_at) SELECT (SELECT user_id FROM issued), coalesce((SELECT expires_at FROM issued), current_timestamp)", hashToken(token), login, ttl.Seconds()).Scan(&userID, &expiresAt)
+	if err != nil {
+		return "", expiresAt, err
+	}
+
+	if err = recordAuditEvent(ctx, tx, AuditPasswordResetRequested, userID, nil, nil); err != nil {
+		return "", expiresAt, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return "", expiresAt, err
+	}
+	if userID == nil {
+		return "", expiresAt, ErrUserNotFound
+	}
+
+	return token, expiresAt, nil
+}
+
+// GetPasswordResetLogin returns the login a valid reset token was issued for,
+// the token is not consumed.
+func (s DBStorage) GetPasswordResetLogin(ctx context.Context, token string) (login string, err error) {
+	err = s.pool.QueryRow(ctx, "SELECT u.login FROM public.password_reset_token t JOIN public.user u ON u.id = t.user_id WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > current_timestamp", hashToken(token)).Scan(&login)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", ErrInvalidResetToken
+		}
+		return "", err
+	}
+
+	return login, nil
+}
+
+func (s DBStorage) ResetPassword(ctx context.Context, token string, password string) (err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx, "UPDATE public.password_reset_token SET used_at = current_timestamp WHERE token_hash = $1 AND used_at IS NULL AND expires_at > current_timestamp RETURNING user_id", hashToken(token)).Scan(&userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	if err = setPassword(ctx, tx, userID, password, "reset"); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s DBStorage) ChangePassword(ctx context.Context, userID int, password string) (err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = setPassword(ctx, tx, userID, password, "change"); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// setPassword stores the new password hash and revokes everything issued for
+// the old credentials: outstanding reset tokens, API keys and login failure
+// counters.
+func setPassword(ctx context.Context, tx pgx.Tx, userID int, password string, method string) (err error) {
+	var login string
+	err = tx.QueryRow(ctx, "UPDATE public.user SET password = $1, password_changed_at = current_timestamp WHERE id = $2 RETURNING login", password, userID).Scan(&login)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.password_reset_token SET used_at = current_timestamp WHERE user_id = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.api_key SET revoked_at = current_timestamp WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM public.login_failure WHERE key = $1", loginKey(login))
+	if err != nil {
+		return err
+	}
+
+	return recordAuditEvent(ctx, tx, AuditPasswordChanged, &userID, nil, map[string]string{"method": method})
+}
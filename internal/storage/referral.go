@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ReferralStatusPending  = "PENDING"
+	ReferralStatusRewarded = "REWARDED"
+	ReferralStatusRejected = "REJECTED"
+
+	referralCodeConstraint = "user_referral_code_key"
+	referralCodeAttempts   = 3
+)
+
+var ErrUnknownReferralCode = errors.New("unknown referral code")
+
+type ReferralPolicy struct {
+	ReferrerBonus float32
+	RefereeBonus  float32
+	MonthlyLimit  int
+	MinAccrual    float32
+}
+
+func (s *DBStorage) SetReferralPolicy(policy ReferralPolicy) {
+	s.referralPolicy = policy
+}
+
+func (s DBStorage) CreateReferredUser(ctx context.Context, login string, password string, referralCode string) (err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var referrerID int
+	err = tx.QueryRow(ctx, "SELECT u.id FROM public.user u WHERE u.referral_code = $1", strings.ToUpper(strings.TrimSpace(referralCode))).Scan(&referrerID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrUnknownReferralCode
+		}
+		return err
+	}
+
+	userID, err := insertUser(ctx, tx, login, password)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO public.referral (referrer_id, referee_id) VALUES ($1, $2)", referrerID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s DBStorage) GetUserReferrals(ctx context.Context, userID int) (referrals UserReferrals, err error) {
+	err = s.pool.QueryRow(ctx, "SELECT u.referral_code FROM public.user u WHERE u.id = $1", userID).Scan(&referrals.Code)
+	if err != nil {
+		return referrals, err
+	}
+
+	err = pgxscan.Select(ctx, s.pool, &referrals.Referrals, "SELECT u.login, r.status, r.referrer_bonus, r.created_at, r.resolved_at FROM public.referral r JOIN public.user u ON u.id = r.referee_id WHERE r.referrer_id = $1 ORDER BY r.created_at DESC, r.id DESC", userID)
+
+	return referrals, err
+}
+
+// pendingReferrer returns the referrer to be rewarded for the first processed
+// order of the user, or zero.
+func (s DBStorage) pendingReferrer(ctx context.Context, tx pgx.Tx, userID int) (referrerID int, err error) {
+	if s.referralPolicy.ReferrerBonus <= 0 && s.referralPolicy.RefereeBonus <= 0 {
+		return 0, nil
+	}
+
+	err = tx.QueryRow(ctx, "SELECT r.referrer_id FROM public.referral r WHERE r.referee_id = $1 AND r.status = 'PENDING'", userID).Scan(&referrerID)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+
+	return referrerID, err
+}
+
+// applyReferral resolves the pending referral of the order owner. The caller
+// has locked the rows of both the referee and the referrer, see pendingReferrer.
+func (s DBStorage) applyReferral(ctx context.Context, tx pgx.Tx, order Order) (refereeBonus float32, err error) {
+	if s.referralPolicy.ReferrerBonus <= 0 && s.referralPolicy.RefereeBonus <= 0 {
+		return 0, nil
+	}
+
+	var referralID, referrerID int
+	err = tx.QueryRow(ctx, "SELECT r.id, r.referrer_id FROM public.referral r WHERE r.referee_id = $1 AND r.status = 'PENDING' FOR UPDATE", order.UserID).Scan(&referralID, &referrerID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	status := ReferralStatusRewarded
+	if order.Accrual < s.referralPolicy.MinAccrual {
+		status = ReferralStatusRejected
+	} else if s.referralPolicy.MonthlyLimit > 0 {
+		var rewarded int
+		err = tx.QueryRow(ctx, "SELECT count(*) FROM public.referral r WHERE r.referrer_id = $1 AND r.status = 'REWARDED' AND r.resolved_at > current_timestamp - interval '30 days'", referrerID).Scan(&rewarded)
+		if err != nil {
+			return 0, err
+		}
+		if rewarded >= s.referralPolicy.MonthlyLimit {
+			status = ReferralStatusRejected
+		}
+	}
+
+	var referrerBonus float32
+	if status == ReferralStatusRewarded {
+		referrerBonus, refereeBonus = s.referralPolicy.ReferrerBonus, s.referralPolicy.RefereeBonus
+
+		credited, err := creditBalance(ctx, tx, referrerID, referrerBonus)
+		if err != nil {
+			return 0, err
+		}
+		if err = s.createLot(ctx, tx, "", credited, referrerID); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.referral SET status = $1, referrer_bonus = $2, referee_bonus = $3, resolved_at = current_timestamp WHERE id = $4", status, referrerBonus, refereeBonus, referralID)
+	if err != nil {
+		return 0, err
+	}
+
+	return refereeBonus, nil
+}
@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/jackc/pgerrcode"
@@ -13,21 +15,78 @@ import (
 
 var (
 	ErrLoginUniqueViolation     = errors.New("login already in use")
+	ErrDuplicateLogins          = errors.New("logins differing only in case have to be renamed")
 	ErrIncorrectUserCredentials = errors.New("incorrect user credentials")
 	ErrHaveOrderBySameUser      = errors.New("order already uploaded by this user")
 	ErrHaveOrderByDiffUser      = errors.New("order already uploaded by different user")
 	ErrNoOrdersFound            = errors.New("no orders found")
+	ErrOrderNotFound            = errors.New("order not found")
+	ErrOrderOwnedByDiffUser     = errors.New("order belongs to different user")
 	ErrInsufficientFunds        = errors.New("insufficient funds on the user balance")
+	ErrWrongSum                 = errors.New("sum must be positive")
 	ErrNoWithdrawalsFound       = errors.New("no withdrawals found")
+	ErrWithdrawalAlreadyExists  = errors.New("withdrawal against this order already exists")
+	ErrWithdrawalCapExceeded    = errors.New("withdrawals against this order exceed the cap")
+	ErrUnknownWithdrawalPolicy  = errors.New("unknown withdrawal policy")
+	ErrWrongWithdrawalOrderCap  = errors.New("withdrawal order cap must be positive")
+	ErrWithdrawalNotFound       = errors.New("withdrawal not found")
+	ErrWithdrawalNotReversible  = errors.New("withdrawal is already reversed or is a reversal itself")
+	ErrOrderNotProcessed        = errors.New("order accrual is not processed")
+	ErrClawbackExceedsAccrual   = errors.New("clawback exceeds remaining order accrual")
+	ErrUnknownClawbackPolicy    = errors.New("unknown clawback policy")
+	ErrHoldNotFound             = errors.New("hold not found")
+	ErrHoldNotActive            = errors.New("hold is already captured, released or expired")
+	ErrIdempotencyKeyReused     = errors.New("idempotency key already used with different request")
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+const (
+	WithdrawalPolicyNone   = "none"
+	WithdrawalPolicyGlobal = "global"
+	WithdrawalPolicyUser   = "user"
+	WithdrawalPolicyCap    = "cap"
+)
+
+type WithdrawalPolicy struct {
+	Scope    string
+	OrderCap float32
+}
+
+func (p WithdrawalPolicy) Validate() error {
+	switch p.Scope {
+	case WithdrawalPolicyNone, WithdrawalPolicyGlobal, WithdrawalPolicyUser:
+		return nil
+	case WithdrawalPolicyCap:
+		if p.OrderCap <= 0 {
+			return ErrWrongWithdrawalOrderCap
+		}
+		return nil
+	}
+	return ErrUnknownWithdrawalPolicy
+}
+
+const (
+	ClawbackPolicyNegative = "negative"
+	ClawbackPolicyPartial  = "partial"
+	ClawbackPolicyDebt     = "debt"
 )
 
 type DBStorage struct {
-	pool *pgxpool.Pool
+	pool             *pgxpool.Pool
+	withdrawalPolicy WithdrawalPolicy
+	clawbackPolicy   string
+	expirationPolicy ExpirationPolicy
+	tierPolicy       TierPolicy
+	referralPolicy   ReferralPolicy
+	transferPolicy   TransferPolicy
+	lockoutPolicy    LockoutPolicy
 }
 
 func NewDBStorage(ctx context.Context, p *pgxpool.Pool) (storage *DBStorage, err error) {
 	storage = &DBStorage{
-		pool: p,
+		pool:             p,
+		withdrawalPolicy: WithdrawalPolicy{Scope: WithdrawalPolicyGlobal},
+		clawbackPolicy:   ClawbackPolicyDebt,
 	}
 
 	err = storage.Prepare(ctx)
@@ -38,6 +97,25 @@ func NewDBStorage(ctx context.Context, p *pgxpool.Pool) (storage *DBStorage, err
 	return storage, nil
 }
 
+func (s *DBStorage) SetWithdrawalPolicy(policy WithdrawalPolicy) (err error) {
+	if err = policy.Validate(); err != nil {
+		return err
+	}
+	s.withdrawalPolicy = policy
+
+	return nil
+}
+
+func (s *DBStorage) SetClawbackPolicy(policy string) (err error) {
+	switch policy {
+	case ClawbackPolicyNegative, ClawbackPolicyPartial, ClawbackPolicyDebt:
+		s.clawbackPolicy = policy
+		return nil
+	}
+
+	return ErrUnknownClawbackPolicy
+}
+
 func (s DBStorage) Prepare(ctx context.Context) (err error) {
 	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.user (id SERIAL PRIMARY KEY, login TEXT UNIQUE NOT NULL, password TEXT NOT NULL, current REAL NOT NULL DEFAULT 0 CHECK (current >= 0), withdrawn REAL NOT NULL DEFAULT 0)")
 	if err != nil {
@@ -49,7 +127,244 @@ func (s DBStorage) Prepare(ctx context.Context) (err error) {
 		return err
 	}
 
-	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_ord_user_id ON public.order(user_id)")
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.order ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS status_changed_at timestamp with time zone NOT NULL DEFAULT (current_timestamp)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.order ADD COLUMN IF NOT EXISTS clawed_back REAL NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.user ADD COLUMN IF NOT EXISTS debt REAL NOT NULL DEFAULT 0 CHECK (debt >= 0)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.accrual_clawback (id SERIAL PRIMARY KEY, order_number TEXT NOT NULL REFERENCES public.order (number), user_id INTEGER REFERENCES public.user (id) NOT NULL, sum REAL NOT NULL, recovered REAL NOT NULL, debt REAL NOT NULL, reason TEXT NOT NULL, created_at timestamp with time zone NOT NULL DEFAULT (current_timestamp))")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.user ADD COLUMN IF NOT EXISTS held REAL NOT NULL DEFAULT 0 CHECK (held >= 0)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.balance_hold (id SERIAL PRIMARY KEY, order_number TEXT NOT NULL, sum REAL NOT NULL CHECK (sum > 0), status TEXT NOT NULL DEFAULT 'HELD', created_at timestamp with time zone NOT NULL DEFAULT (current_timestamp), expires_at timestamp with time zone NOT NULL, resolved_at timestamp with time zone, user_id INTEGER REFERENCES public.user (id) NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_hold_expires_at_held ON public.balance_hold(expires_at) WHERE status = 'HELD'")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.accrual_lot (id SERIAL PRIMARY KEY, order_number TEXT NOT NULL DEFAULT '', amount REAL NOT NULL, remaining REAL NOT NULL CHECK (remaining >= 0), accrued_at timestamp with time zone NOT NULL DEFAULT (current_timestamp), expires_at timestamp with time zone NOT NULL, user_id INTEGER REFERENCES public.user (id) NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_lot_user_id_expires_at ON public.accrual_lot(user_id, expires_at) WHERE remaining > 0")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.point_expiration (id SERIAL PRIMARY KEY, lot_id INTEGER REFERENCES public.accrual_lot (id) NOT NULL, sum REAL NOT NULL, expired_at timestamp with time zone NOT NULL DEFAULT (current_timestamp), user_id INTEGER REFERENCES public.user (id) NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.user ADD COLUMN IF NOT EXISTS tier TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.order ADD COLUMN IF NOT EXISTS tier_bonus REAL NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.order ADD COLUMN IF NOT EXISTS bonus REAL NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.campaign (id SERIAL PRIMARY KEY, name TEXT NOT NULL, kind TEXT NOT NULL, value REAL NOT NULL CHECK (value > 0), starts_at timestamp with time zone NOT NULL, ends_at timestamp with time zone NOT NULL, first_order_only BOOLEAN NOT NULL DEFAULT false, min_accrual REAL NOT NULL DEFAULT 0, budget REAL CHECK (budget >= 0), spent REAL NOT NULL DEFAULT 0)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.campaign_bonus (id SERIAL PRIMARY KEY, campaign_id INTEGER REFERENCES public.campaign (id) NOT NULL, order_number TEXT NOT NULL REFERENCES public.order (number), sum REAL NOT NULL, created_at timestamp with time zone NOT NULL DEFAULT (current_timestamp), user_id INTEGER REFERENCES public.user (id) NOT NULL, UNIQUE (campaign_id, order_number))")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.user ADD COLUMN IF NOT EXISTS referral_code TEXT UNIQUE NOT NULL DEFAULT upper(substr(md5(random()::text), 1, 8))")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.referral (id SERIAL PRIMARY KEY, referrer_id INTEGER REFERENCES public.user (id) NOT NULL, referee_id INTEGER UNIQUE REFERENCES public.user (id) NOT NULL, status TEXT NOT NULL DEFAULT 'PENDING', referrer_bonus REAL NOT NULL DEFAULT 0, referee_bonus REAL NOT NULL DEFAULT 0, created_at timestamp with time zone NOT NULL DEFAULT (current_timestamp), resolved_at timestamp with time zone)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_referral_referrer_id ON public.referral(referrer_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.transfer (id SERIAL PRIMARY KEY, from_user_id INTEGER REFERENCES public.user (id) NOT NULL, to_user_id INTEGER REFERENCES public.user (id) NOT NULL, sum REAL NOT NULL CHECK (sum > 0), created_at timestamp with time zone NOT NULL DEFAULT (current_timestamp))")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_transfer_from_user_id_created_at ON public.transfer(from_user_id, created_at)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_transfer_to_user_id_created_at ON public.transfer(to_user_id, created_at)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.user ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'support', 'admin'))")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.balance_adjustment (id SERIAL PRIMARY KEY, user_id INTEGER REFERENCES public.user (id) NOT NULL, sum REAL NOT NULL CHECK (sum <> 0), reason TEXT NOT NULL, operator TEXT NOT NULL, created_at timestamp with time zone NOT NULL DEFAULT (current_timestamp))")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.audit_event (id SERIAL PRIMARY KEY, type TEXT NOT NULL, actor TEXT NOT NULL, user_id INTEGER REFERENCES public.user (id), ip TEXT NOT NULL DEFAULT '', user_agent TEXT NOT NULL DEFAULT '', before JSONB, after JSONB, created_at timestamp with time zone NOT NULL DEFAULT (current_timestamp))")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_audit_user_id_created_at ON public.audit_event(user_id, created_at)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE OR REPLACE FUNCTION public.audit_event_immutable() RETURNS trigger AS $$ BEGIN RAISE EXCEPTION 'audit_event is append-only'; END; $$ LANGUAGE plpgsql")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'trg_audit_event_immutable') THEN CREATE TRIGGER trg_audit_event_immutable BEFORE UPDATE OR DELETE OR TRUNCATE ON public.audit_event FOR EACH STATEMENT EXECUTE FUNCTION public.audit_event_immutable(); END IF; END $$")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.login_failure (key TEXT PRIMARY KEY, failures INTEGER NOT NULL DEFAULT 0, last_failure_at timestamp with time zone NOT NULL, locked_until timestamp with time zone)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_login_failure_last_failure_at ON public.login_failure(last_failure_at)")
+	if err != nil {
+		return err
+	}
+
+	if err = s.checkDuplicateLogins(ctx); err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS idx_user_login_lower ON public.user(lower(login))")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.user ADD COLUMN IF NOT EXISTS password_changed_at timestamp with time zone")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.password_reset_token (token_hash TEXT PRIMARY KEY, user_id INTEGER REFERENCES public.user (id) NOT NULL, created_at timestamp with time zone NOT NULL DEFAULT (current_timestamp), expires_at timestamp with time zone NOT NULL, used_at timestamp with time zone)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.api_key (id SERIAL PRIMARY KEY, user_id INTEGER REFERENCES public.user (id) NOT NULL, name TEXT NOT NULL, prefix TEXT NOT NULL, key_hash TEXT UNIQUE NOT NULL, scopes TEXT[] NOT NULL, created_at timestamp with time zone NOT NULL DEFAULT (current_timestamp), last_used_at timestamp with time zone, revoked_at timestamp with time zone)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_api_key_user_id ON public.api_key(user_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.user_event (id BIGSERIAL PRIMARY KEY, user_id INTEGER REFERENCES public.user (id) NOT NULL, type TEXT NOT NULL, data JSONB NOT NULL, tx_id BIGINT NOT NULL DEFAULT txid_current(), created_at timestamp with time zone NOT NULL DEFAULT (current_timestamp))")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_user_event_user_id_id ON public.user_event(user_id, id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_user_event_created_at ON public.user_event(created_at)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE OR REPLACE FUNCTION public.user_event_notify() RETURNS trigger AS $$ BEGIN PERFORM pg_notify('user_event', NEW.user_id::text); RETURN NULL; END; $$ LANGUAGE plpgsql")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'trg_user_event_notify') THEN CREATE TRIGGER trg_user_event_notify AFTER INSERT ON public.user_event FOR EACH ROW EXECUTE FUNCTION public.user_event_notify(); END IF; END $$")
+	if err != nil {
+		return err
+	}
+
+	// The events are inserted by deferred triggers right before the commit.
+	// Taking the ids under a transaction lock makes them visible in id order,
+	// so a stream resuming after an id can not skip an event committed later.
+	_, err = s.pool.Exec(ctx, "CREATE OR REPLACE FUNCTION public.user_event_id() RETURNS trigger AS $$ BEGIN PERFORM pg_advisory_xact_lock(hashtext('public.user_event')); NEW.id := nextval(pg_get_serial_sequence('public.user_event', 'id')); RETURN NEW; END; $$ LANGUAGE plpgsql")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'trg_user_event_id') THEN CREATE TRIGGER trg_user_event_id BEFORE INSERT ON public.user_event FOR EACH ROW EXECUTE FUNCTION public.user_event_id(); END IF; END $$")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE OR REPLACE FUNCTION public.user_event_order_status() RETURNS trigger AS $$ BEGIN INSERT INTO public.user_event (user_id, type, data) SELECT o.user_id, 'order.status', jsonb_build_object('number', o.number, 'status', o.status, 'accrual', o.accrual, 'tier_bonus', o.tier_bonus, 'bonus', o.bonus) FROM public.order o WHERE o.id = NEW.id; RETURN NULL; END; $$ LANGUAGE plpgsql")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'trg_user_event_order_status') THEN CREATE CONSTRAINT TRIGGER trg_user_event_order_status AFTER UPDATE OF status ON public.order DEFERRABLE INITIALLY DEFERRED FOR EACH ROW WHEN (OLD.status IS DISTINCT FROM NEW.status) EXECUTE FUNCTION public.user_event_order_status(); END IF; END $$")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE OR REPLACE FUNCTION public.user_event_balance() RETURNS trigger AS $$ BEGIN IF NOT EXISTS (SELECT 1 FROM public.user_event e WHERE e.user_id = NEW.id AND e.type = 'balance' AND e.tx_id = txid_current()) THEN INSERT INTO public.user_event (user_id, type, data) SELECT u.id, 'balance', jsonb_build_object('current', u.current, 'withdrawn', u.withdrawn, 'held', u.held, 'debt', u.debt) FROM public.user u WHERE u.id = NEW.id; END IF; RETURN NULL; END; $$ LANGUAGE plpgsql")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'trg_user_event_balance') THEN CREATE CONSTRAINT TRIGGER trg_user_event_balance AFTER UPDATE OF current, withdrawn, held, debt ON public.user DEFERRABLE INITIALLY DEFERRED FOR EACH ROW WHEN (OLD.current IS DISTINCT FROM NEW.current OR OLD.withdrawn IS DISTINCT FROM NEW.withdrawn OR OLD.held IS DISTINCT FROM NEW.held OR OLD.debt IS DISTINCT FROM NEW.debt) EXECUTE FUNCTION public.user_event_balance(); END IF; END $$")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "DROP INDEX IF EXISTS idx_ord_user_id")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_ord_user_id_uploaded_at ON public.order(user_id, uploaded_at)")
 	if err != nil {
 		return err
 	}
@@ -64,7 +379,27 @@ func (s DBStorage) Prepare(ctx context.Context) (err error) {
 		return err
 	}
 
-	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_wd_user_id ON public.withdrawal(user_id)")
+	_, err = s.pool.Exec(ctx, "ALTER TABLE public.withdrawal ADD COLUMN IF NOT EXISTS reversed_at timestamp with time zone, ADD COLUMN IF NOT EXISTS reversal_reason TEXT, ADD COLUMN IF NOT EXISTS reversal_of INTEGER REFERENCES public.withdrawal (id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "DROP INDEX IF EXISTS idx_wd_user_id")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_wd_user_id_processed_at ON public.withdrawal(user_id, processed_at)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS public.idempotency_key (key TEXT NOT NULL, user_id INTEGER REFERENCES public.user (id) NOT NULL, request_hash TEXT NOT NULL, status_code INTEGER, content_type TEXT, response BYTEA, expires_at timestamp with time zone NOT NULL, PRIMARY KEY (user_id, key))")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_idempotency_key_expires_at ON public.idempotency_key(expires_at)")
 	if err != nil {
 		return err
 	}
@@ -72,17 +407,75 @@ func (s DBStorage) Prepare(ctx context.Context) (err error) {
 	return nil
 }
 
+// checkDuplicateLogins lists the logins that keep idx_user_login_lower from
+// being created. Once the index exists there can not be any.
+func (s DBStorage) checkDuplicateLogins(ctx context.Context) (err error) {
+	var duplicates []string
+	err = pgxscan.Select(ctx, s.pool, &duplicates, "SELECT string_agg(u.login, ', ' ORDER BY u.id) FROM public.user u WHERE to_regclass('public.idx_user_login_lower') IS NULL GROUP BY lower(u.login) HAVING count(*) > 1 ORDER BY 1")
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%w: %s", ErrDuplicateLogins, strings.Join(duplicates, "; "))
+	}
+
+	return nil
+}
+
 func (s DBStorage) CreateUser(ctx context.Context, login string, password string) (err error) {
-	_, err = s.pool.Exec(ctx, "INSERT INTO public.user (login, password) VALUES ($1, $2)", login, password)
+	tx, err := s.pool.Begin(ctx)
 	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = insertUser(ctx, tx, login, password); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// insertUser retries the insert when the generated referral code collides with
+// an existing one, every other unique violation is a taken login.
+func insertUser(ctx context.Context, tx pgx.Tx, login string, password string) (userID int, err error) {
+	for attempt := 1; ; attempt++ {
+		userID, err = tryInsertUser(ctx, tx, login, password)
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			return ErrLoginUniqueViolation
+			if pgErr.ConstraintName != referralCodeConstraint {
+				return 0, ErrLoginUniqueViolation
+			}
+			if attempt < referralCodeAttempts {
+				continue
+			}
 		}
-		return err
+		if err != nil {
+			return 0, err
+		}
+		break
 	}
 
-	return nil
+	err = recordAuditEvent(ctx, tx, AuditUserRegistered, &userID, nil, map[string]string{"login": login})
+
+	return userID, err
+}
+
+// tryInsertUser runs the insert in a savepoint, so that a failed attempt does
+// not abort the transaction.
+func tryInsertUser(ctx context.Context, tx pgx.Tx, login string, password string) (userID int, err error) {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer sp.Rollback(ctx)
+
+	err = sp.QueryRow(ctx, "INSERT INTO public.user (login, password) VALUES ($1, $2) RETURNING id", login, password).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, sp.Commit(ctx)
 }
 
 func (s DBStorage) GetUserCredentials(ctx context.Context, login string) (id int, password string, err error) {
@@ -117,31 +510,122 @@ func (s DBStorage) InsertNewOrder(ctx context.Context, orderNumber string, userI
 	return nil
 }
 
-func (s DBStorage) GetUserOrders(ctx context.Context, userID int) (orders []Order, err error) {
-	err = pgxscan.Select(ctx, s.pool, &orders, "SELECT o.number, o.status, o.accrual, o.uploaded_at FROM public.order o WHERE o.user_id = $1", userID)
+func (s DBStorage) InsertNewOrders(ctx context.Context, orderNumbers []string, userID int) (results []error, err error) {
+	tx, err := s.pool.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback(ctx)
+
+	results = make([]error, len(orderNumbers))
+	for i, orderNumber := range orderNumbers {
+		var id int
+		err = tx.QueryRow(ctx, "INSERT INTO public.order (number, status, user_id) VALUES ($1, 'NEW', $2) ON CONFLICT (number) DO NOTHING RETURNING id", orderNumber, userID).Scan(&id)
+		if err == nil {
+			continue
+		}
+		if err != pgx.ErrNoRows {
+			return nil, err
+		}
+
+		var orderUserID int
+		err = tx.QueryRow(ctx, "SELECT o.user_id FROM public.order o WHERE o.number = $1", orderNumber).Scan(&orderUserID)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = ErrHaveOrderBySameUser
+		if userID != orderUserID {
+			results[i] = ErrHaveOrderByDiffUser
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s DBStorage) GetUserOrders(ctx context.Context, userID int, filter OrderFilter) (orders []Order, next *Cursor, err error) {
+	qb := queryBuilder{}
+	qb.add("o.user_id = ?", userID)
+	if len(filter.Statuses) > 0 {
+		qb.add("o.status = ANY(?)", filter.Statuses)
+	}
+	if !filter.UploadedFrom.IsZero() {
+		qb.add("o.uploaded_at >= ?", filter.UploadedFrom)
+	}
+	if !filter.UploadedTo.IsZero() {
+		qb.add("o.uploaded_at < ?", filter.UploadedTo)
+	}
+	orderBy := qb.page("o.uploaded_at", "o.id", filter.Page)
+
+	err = pgxscan.Select(ctx, s.pool, &orders, "SELECT o.id, o.number, o.status, o.accrual, o.clawed_back, o.tier_bonus, o.bonus, o.uploaded_at FROM public.order o"+qb.whereClause()+orderBy, qb.args...)
+	if err != nil {
+		return nil, nil, err
+	}
 	if len(orders) == 0 {
-		return nil, ErrNoOrdersFound
+		return nil, nil, ErrNoOrdersFound
 	}
 
-	return orders, nil
+	if filter.Limit > 0 && len(orders) > filter.Limit {
+		orders = orders[:filter.Limit]
+		last := orders[len(orders)-1]
+		next = &Cursor{At: last.UploadedAt, ID: last.ID}
+	}
+
+	return orders, next, nil
 }
 
-func (s DBStorage) GetUserBalance(ctx context.Context, userID int) (current float32, withdrawn float32, err error) {
-	err = s.pool.QueryRow(ctx, "SELECT u.current, u.withdrawn FROM public.user u WHERE u.id = $1", userID).Scan(&current, &withdrawn)
+func (s DBStorage) GetUserOrder(ctx context.Context, orderNumber string, userID int) (order OrderDetails, err error) {
+	err = pgxscan.Get(ctx, s.pool, &order, "SELECT o.id, o.number, o.status, o.accrual, o.clawed_back, o.tier_bonus, o.bonus, o.uploaded_at, o.user_id, o.attempts, o.status_changed_at FROM public.order o WHERE o.number = $1", orderNumber)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return order, ErrOrderNotFound
+		}
+		return order, err
+	}
+	if order.UserID != userID {
+		return OrderDetails{}, ErrOrderOwnedByDiffUser
+	}
 
-	return current, withdrawn, err
+	return order, nil
+}
+
+func (s DBStorage) GetUserBalance(ctx context.Context, userID int) (balance Balance, err error) {
+	err = s.pool.QueryRow(ctx, "SELECT u.current, u.withdrawn, u.held, u.debt FROM public.user u WHERE u.id = $1", userID).Scan(&balance.Current, &balance.Withdrawn, &balance.Held, &balance.Debt)
+	if err != nil {
+		return balance, err
+	}
+
+	if s.clawbackPolicy == ClawbackPolicyNegative {
+		balance.Current -= balance.Debt
+		balance.Debt = 0
+	}
+
+	balance.Expiring, err = s.getUpcomingExpirations(ctx, userID)
+	if err != nil {
+		return balance, err
+	}
+
+	return balance, nil
 }
 
 func (s DBStorage) WithdrawFromUserBalance(ctx context.Context, orderNumber string, sum float32, userID int) (err error) {
+	if sum <= 0 {
+		return ErrWrongSum
+	}
+
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
 
+	before, err := getBalanceSnapshot(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(ctx, "UPDATE public.user SET current = current - $1, withdrawn = withdrawn + $1 WHERE id = $2", sum, userID)
 	if err != nil {
 		var pgErr *pgconn.PgError
@@ -151,25 +635,154 @@ func (s DBStorage) WithdrawFromUserBalance(ctx context.Context, orderNumber stri
 		return err
 	}
 
+	if err = s.consumeLots(ctx, tx, sum, userID); err != nil {
+		return err
+	}
+
+	if err = s.insertWithdrawal(ctx, tx, orderNumber, sum, userID); err != nil {
+		return err
+	}
+
+	if err = recordBalanceChange(ctx, tx, AuditWithdrawal, userID, before, nil, map[string]any{"order": orderNumber, "sum": sum}); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s DBStorage) insertWithdrawal(ctx context.Context, tx pgx.Tx, orderNumber string, sum float32, userID int) (err error) {
+	if err = s.checkWithdrawalPolicy(ctx, tx, orderNumber, sum, userID); err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(ctx, "INSERT INTO public.withdrawal (order_number, sum, user_id) VALUES ($1, $2, $3)", orderNumber, sum, userID)
+
+	return err
+}
+
+func (s DBStorage) checkWithdrawalPolicy(ctx context.Context, tx pgx.Tx, orderNumber string, sum float32, userID int) (err error) {
+	if s.withdrawalPolicy.Scope == WithdrawalPolicyNone {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('withdrawal:' || $1))", orderNumber)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	var total float32
+	switch s.withdrawalPolicy.Scope {
+	case WithdrawalPolicyUser:
+		err = tx.QueryRow(ctx, "SELECT count(*) FILTER (WHERE wd.reversed_at IS NULL AND wd.reversal_of IS NULL), coalesce(sum(wd.sum), 0) FROM public.withdrawal wd WHERE wd.order_number = $1 AND wd.user_id = $2", orderNumber, userID).Scan(&count, &total)
+	default:
+		err = tx.QueryRow(ctx, "SELECT count(*) FILTER (WHERE wd.reversed_at IS NULL AND wd.reversal_of IS NULL), coalesce(sum(wd.sum), 0) FROM public.withdrawal wd WHERE wd.order_number = $1", orderNumber).Scan(&count, &total)
+	}
 	if err != nil {
 		return err
 	}
 
-	tx.Commit(ctx)
+	if s.withdrawalPolicy.Scope == WithdrawalPolicyCap {
+		if total+sum > s.withdrawalPolicy.OrderCap {
+			return ErrWithdrawalCapExceeded
+		}
+		return nil
+	}
+	if count > 0 {
+		return ErrWithdrawalAlreadyExists
+	}
+
 	return nil
 }
 
-func (s DBStorage) GetUserWithdrawals(ctx context.Context, userID int) (withdrawals []Withdrawal, err error) {
-	err = pgxscan.Select(ctx, s.pool, &withdrawals, "SELECT wd.order_number, wd.sum, wd.processed_at FROM public.withdrawal wd WHERE wd.user_id = $1", userID)
+func (s DBStorage) ReverseWithdrawal(ctx context.Context, withdrawalID int, reason string) (err error) {
+	tx, err := s.pool.Begin(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var w Withdrawal
+	var userID int
+	err = tx.QueryRow(ctx, "SELECT wd.order_number, wd.sum, wd.user_id, wd.reversed_at, wd.reversal_of FROM public.withdrawal wd WHERE wd.id = $1 FOR UPDATE", withdrawalID).Scan(&w.OrderNumber, &w.Sum, &userID, &w.ReversedAt, &w.ReversalOf)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrWithdrawalNotFound
+		}
+		return err
+	}
+	if w.ReversedAt != nil || w.ReversalOf != nil {
+		return ErrWithdrawalNotReversible
+	}
+
+	before, err := getBalanceSnapshot(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.user SET withdrawn = withdrawn - $1 WHERE id = $2", w.Sum, userID)
+	if err != nil {
+		return err
+	}
+
+	credited, err := creditBalance(ctx, tx, userID, w.Sum)
+	if err != nil {
+		return err
+	}
+
+	if err = s.restoreLots(ctx, tx, credited, userID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.withdrawal SET reversed_at = current_timestamp, reversal_reason = $1 WHERE id = $2", reason, withdrawalID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO public.withdrawal (order_number, sum, user_id, reversal_of, reversal_reason) VALUES ($1, $2, $3, $4, $5)", w.OrderNumber, -w.Sum, userID, withdrawalID, reason)
+	if err != nil {
+		return err
+	}
+
+	err = recordBalanceChange(ctx, tx, AuditWithdrawalReversed, userID, before, nil, map[string]any{"withdrawal": withdrawalID, "order": w.OrderNumber, "sum": w.Sum, "reason": reason})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s DBStorage) GetUserWithdrawals(ctx context.Context, userID int, filter WithdrawalFilter) (withdrawals []Withdrawal, next *Cursor, err error) {
+	qb := queryBuilder{}
+	qb.add("wd.user_id = ?", userID)
+	filter.apply(&qb)
+	orderBy := qb.page("wd.processed_at", "wd.id", filter.Page)
+
+	err = pgxscan.Select(ctx, s.pool, &withdrawals, "SELECT wd.id, wd.order_number, wd.sum, wd.processed_at, wd.reversed_at, wd.reversal_reason, wd.reversal_of FROM public.withdrawal wd"+qb.whereClause()+orderBy, qb.args...)
+	if err != nil {
+		return nil, nil, err
 	}
 	if len(withdrawals) == 0 {
-		return nil, ErrNoWithdrawalsFound
+		return nil, nil, ErrNoWithdrawalsFound
 	}
 
-	return withdrawals, nil
+	if filter.Limit > 0 && len(withdrawals) > filter.Limit {
+		withdrawals = withdrawals[:filter.Limit]
+		last := withdrawals[len(withdrawals)-1]
+		next = &Cursor{At: last.ProcessedAt, ID: last.ID}
+	}
+
+	return withdrawals, next, nil
+}
+
+func (s DBStorage) GetUserWithdrawalsSummary(ctx context.Context, userID int, filter WithdrawalFilter) (summary WithdrawalSummary, err error) {
+	qb := queryBuilder{}
+	qb.add("wd.user_id = ?", userID)
+	filter.apply(&qb)
+
+	err = s.pool.QueryRow(ctx, "SELECT count(*), coalesce(sum(wd.sum), 0) FROM public.withdrawal wd"+qb.whereClause(), qb.args...).Scan(&summary.Count, &summary.Total)
+
+	return summary, err
 }
 
 func (s DBStorage) UpdateOrderStatus(ctx context.Context, order Order) (err error) {
@@ -179,19 +792,78 @@ func (s DBStorage) UpdateOrderStatus(ctx context.Context, order Order) (err erro
 	}
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx, "UPDATE public.order SET status = $1, accrual = $2 WHERE number = $3", order.Status, order.Accrual, order.Number)
+	// The referrer is credited in the same transaction, both rows are locked
+	// together in id order, like in TransferPoints.
+	userIDs := []int{order.UserID}
+	if order.Status == "PROCESSED" {
+		referrerID, err := s.pendingReferrer(ctx, tx, order.UserID)
+		if err != nil {
+			return err
+		}
+		if referrerID != 0 {
+			userIDs = append(userIDs, referrerID)
+		}
+	}
+	_, err = tx.Exec(ctx, "SELECT u.id FROM public.user u WHERE u.id = ANY($1) ORDER BY u.id FOR UPDATE", userIDs)
+	if err != nil {
+		return err
+	}
+
+	var referralBonus float32
+	var tier string
+	err = tx.QueryRow(ctx, "SELECT u.tier FROM public.user u WHERE u.id = $1", order.UserID).Scan(&tier)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(ctx, "UPDATE public.user SET current = current + $1 WHERE id = $2", order.Accrual, order.UserID)
+	before, err := getBalanceSnapshot(ctx, tx, order.UserID)
 	if err != nil {
 		return err
 	}
+	var previousStatus string
+	err = tx.QueryRow(ctx, "SELECT o.status FROM public.order o WHERE o.number = $1", order.Number).Scan(&previousStatus)
+	if err != nil {
+		return err
+	}
+	order.TierBonus = order.Accrual * (s.tierMultiplier(tier) - 1)
 
-	tx.Commit(ctx)
+	_, err = tx.Exec(ctx, "UPDATE public.order SET status = $1, accrual = $2, tier_bonus = $3, status_changed_at = current_timestamp WHERE number = $4", order.Status, order.Accrual, order.TierBonus, order.Number)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	if order.Status == "PROCESSED" {
+		order.Bonus, err = s.applyCampaigns(ctx, tx, order)
+		if err != nil {
+			return err
+		}
+		referralBonus, err = s.applyReferral(ctx, tx, order)
+		if err != nil {
+			return err
+		}
+	}
+
+	credited, err := creditBalance(ctx, tx, order.UserID, order.Accrual+order.TierBonus+order.Bonus+referralBonus)
+	if err != nil {
+		return err
+	}
+
+	if err = s.createLot(ctx, tx, order.Number, credited, order.UserID); err != nil {
+		return err
+	}
+
+	err = recordBalanceChange(ctx, tx, AuditOrderStatusChanged, order.UserID, before, map[string]any{"order": order.Number, "status": previousStatus}, map[string]any{"order": order.Number, "status": order.Status, "accrual": order.Accrual, "credited": credited})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s DBStorage) IncrementOrderAttempts(ctx context.Context, orderNumber string) (err error) {
+	_, err = s.pool.Exec(ctx, "UPDATE public.order SET attempts = attempts + 1 WHERE number = $1", orderNumber)
+
+	return err
 }
 
 func (s DBStorage) GetNewOrders(ctx context.Context) (orders []Order, err error) {
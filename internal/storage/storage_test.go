@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -186,6 +188,76 @@ func TestDBStorage_InsertNewOrder(t *testing.T) {
 	}
 }
 
+func TestDBStorage_InsertNewOrders(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstOrderNumber := goluhn.Generate(8)
+	secondOrderNumber := goluhn.Generate(8)
+
+	tests := []struct {
+		name         string
+		orderNumbers []string
+		userID       int
+		want         []error
+	}{
+		{
+			name:         "Positive_NewOrders",
+			orderNumbers: []string{firstOrderNumber, secondOrderNumber},
+			userID:       userID,
+			want:         []error{nil, nil},
+		},
+		{
+			name:         "Negative_SameOrders_SameUser",
+			orderNumbers: []string{firstOrderNumber, secondOrderNumber},
+			userID:       userID,
+			want:         []error{ErrHaveOrderBySameUser, ErrHaveOrderBySameUser},
+		},
+		{
+			name:         "Negative_SameOrder_DiffUser",
+			orderNumbers: []string{firstOrderNumber},
+			userID:       userID - 1,
+			want:         []error{ErrHaveOrderByDiffUser},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got, err := storage.InsertNewOrders(ctx, tt.orderNumbers, tt.userID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
 func TestDBStorage_GetUserOrders(t *testing.T) {
 	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -223,10 +295,18 @@ func TestDBStorage_GetUserOrders(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	secondOrderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, secondOrderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	tests := []struct {
 		name        string
 		orderNumber string
 		userID      int
+		filter      OrderFilter
+		hasNext     bool
 		errType     error
 	}{
 		{
@@ -235,6 +315,35 @@ func TestDBStorage_GetUserOrders(t *testing.T) {
 			userID:      userID,
 			errType:     nil,
 		},
+		{
+			name:        "Positive_FirstPage",
+			orderNumber: orderNumber,
+			userID:      userID,
+			filter:      OrderFilter{Page: Page{Limit: 1}},
+			hasNext:     true,
+			errType:     nil,
+		},
+		{
+			name:        "Positive_NewestFirst",
+			orderNumber: secondOrderNumber,
+			userID:      userID,
+			filter:      OrderFilter{Page: Page{Desc: true}},
+			errType:     nil,
+		},
+		{
+			name:        "Positive_FilteredByStatus",
+			orderNumber: orderNumber,
+			userID:      userID,
+			filter:      OrderFilter{Statuses: []string{"NEW"}},
+			errType:     nil,
+		},
+		{
+			name:        "Negative_NoOrdersWithStatus",
+			orderNumber: "",
+			userID:      userID,
+			filter:      OrderFilter{Statuses: []string{"PROCESSED"}},
+			errType:     ErrNoOrdersFound,
+		},
 		{
 			name:        "Negative_NoOrdersFound",
 			orderNumber: "",
@@ -246,13 +355,93 @@ func TestDBStorage_GetUserOrders(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
-			gotOrders, err := storage.GetUserOrders(ctx, tt.userID)
+			gotOrders, next, err := storage.GetUserOrders(ctx, tt.userID, tt.filter)
 			var gotOrderNumber string
 			if len(gotOrders) > 0 {
 				gotOrderNumber = gotOrders[0].Number
 			}
 			assert.Equal(t, tt.orderNumber, gotOrderNumber)
+			assert.Equal(t, tt.hasNext, next != nil)
+			assert.Equal(t, tt.errType, err)
+		})
+	}
+}
+
+func TestDBStorage_GetUserOrder(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, orderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.IncrementOrderAttempts(dbCtx, orderNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		orderNumber string
+		userID      int
+		attempts    int
+		errType     error
+	}{
+		{
+			name:        "Positive_FoundOrder",
+			orderNumber: orderNumber,
+			userID:      userID,
+			attempts:    1,
+			errType:     nil,
+		},
+		{
+			name:        "Negative_OrderOwnedByDiffUser",
+			orderNumber: orderNumber,
+			userID:      userID + 1,
+			errType:     ErrOrderOwnedByDiffUser,
+		},
+		{
+			name:        "Negative_OrderNotFound",
+			orderNumber: orderNumber + "0",
+			userID:      userID,
+			errType:     ErrOrderNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			order, err := storage.GetUserOrder(ctx, tt.orderNumber, tt.userID)
 			assert.Equal(t, tt.errType, err)
+			assert.Equal(t, tt.attempts, order.Attempts)
 		})
 	}
 }
@@ -308,7 +497,7 @@ func TestDBStorage_GetUserBalance(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
-			_, _, err := storage.GetUserBalance(ctx, tt.userID)
+			_, err := storage.GetUserBalance(ctx, tt.userID)
 			assert.Equal(t, tt.wantErr, err != nil)
 		})
 	}
@@ -383,7 +572,113 @@ func TestDBStorage_WithdrawFromUserBalance(t *testing.T) {
 	}
 }
 
-func TestDBStorage_GetUserWithdrawals(t *testing.T) {
+func TestDBStorage_SetWithdrawalPolicy(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, orderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: 1000, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withdrawalOrderNumber := goluhn.Generate(8)
+
+	tests := []struct {
+		name    string
+		policy  WithdrawalPolicy
+		sum     float32
+		errType error
+	}{
+		{
+			name:    "Positive_FirstWithdrawal",
+			policy:  WithdrawalPolicy{Scope: WithdrawalPolicyGlobal},
+			sum:     10,
+			errType: nil,
+		},
+		{
+			name:    "Negative_WithdrawalAlreadyExists_Global",
+			policy:  WithdrawalPolicy{Scope: WithdrawalPolicyGlobal},
+			sum:     10,
+			errType: ErrWithdrawalAlreadyExists,
+		},
+		{
+			name:    "Negative_WithdrawalAlreadyExists_User",
+			policy:  WithdrawalPolicy{Scope: WithdrawalPolicyUser},
+			sum:     10,
+			errType: ErrWithdrawalAlreadyExists,
+		},
+		{
+			name:    "Negative_NegativeSum",
+			policy:  WithdrawalPolicy{Scope: WithdrawalPolicyCap, OrderCap: 30},
+			sum:     -20,
+			errType: ErrWrongSum,
+		},
+		{
+			name:    "Positive_WithinCap",
+			policy:  WithdrawalPolicy{Scope: WithdrawalPolicyCap, OrderCap: 30},
+			sum:     20,
+			errType: nil,
+		},
+		{
+			name:    "Negative_CapExceeded",
+			policy:  WithdrawalPolicy{Scope: WithdrawalPolicyCap, OrderCap: 30},
+			sum:     1,
+			errType: ErrWithdrawalCapExceeded,
+		},
+		{
+			name:    "Positive_NoPolicy",
+			policy:  WithdrawalPolicy{Scope: WithdrawalPolicyNone},
+			sum:     10,
+			errType: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			assert.NoError(t, storage.SetWithdrawalPolicy(tt.policy))
+			err := storage.WithdrawFromUserBalance(ctx, withdrawalOrderNumber, tt.sum, userID)
+			assert.Equal(t, tt.errType, err)
+		})
+	}
+
+	assert.Equal(t, ErrUnknownWithdrawalPolicy, storage.SetWithdrawalPolicy(WithdrawalPolicy{Scope: "order"}))
+	assert.Equal(t, ErrWrongWithdrawalOrderCap, storage.SetWithdrawalPolicy(WithdrawalPolicy{Scope: WithdrawalPolicyCap}))
+}
+
+func TestDBStorage_ReverseWithdrawal(t *testing.T) {
 	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -433,42 +728,52 @@ func TestDBStorage_GetUserWithdrawals(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	withdrawals, _, err := storage.GetUserWithdrawals(dbCtx, userID, WithdrawalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withdrawalID := withdrawals[0].ID
 
 	tests := []struct {
-		name        string
-		orderNumber string
-		userID      int
-		errType     error
+		name         string
+		withdrawalID int
+		current      float32
+		errType      error
 	}{
 		{
-			name:        "Positive_FoundOrder",
-			orderNumber: orderNumber,
-			userID:      userID,
-			errType:     nil,
+			name:         "Positive_Reversed",
+			withdrawalID: withdrawalID,
+			current:      sum,
+			errType:      nil,
 		},
 		{
-			name:        "Negative_NoWithdrawalsFound",
-			orderNumber: "",
-			userID:      userID + 1,
-			errType:     ErrNoWithdrawalsFound,
+			name:         "Negative_AlreadyReversed",
+			withdrawalID: withdrawalID,
+			current:      sum,
+			errType:      ErrWithdrawalNotReversible,
+		},
+		{
+			name:         "Negative_WithdrawalNotFound",
+			withdrawalID: -1,
+			current:      sum,
+			errType:      ErrWithdrawalNotFound,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
-			gotWithdrawals, err := storage.GetUserWithdrawals(ctx, tt.userID)
-			var gotOrderNumber string
-			if len(gotWithdrawals) > 0 {
-				gotOrderNumber = gotWithdrawals[0].OrderNumber
-			}
-			assert.Equal(t, tt.orderNumber, gotOrderNumber)
+			err := storage.ReverseWithdrawal(ctx, tt.withdrawalID, "order cancelled")
 			assert.Equal(t, tt.errType, err)
+			balance, err := storage.GetUserBalance(ctx, userID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.current, balance.Current)
+			assert.Equal(t, float32(0), balance.Withdrawn)
 		})
 	}
 }
 
-func TestDBStorage_UpdateOrderStatus(t *testing.T) {
+func TestDBStorage_GetUserWithdrawals(t *testing.T) {
 	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -509,29 +814,1566 @@ func TestDBStorage_UpdateOrderStatus(t *testing.T) {
 		t.Fatal(err)
 	}
 	sum := float32(sum64)
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: sum, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	order := Order{
-		Number:  orderNumber,
-		Status:  "PROCESSED",
-		Accrual: sum,
-		UserID:  userID,
+	err = storage.WithdrawFromUserBalance(dbCtx, orderNumber, sum, userID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	tests := []struct {
-		name    string
-		wantErr bool
+		name        string
+		orderNumber string
+		userID      int
+		filter      WithdrawalFilter
+		errType     error
 	}{
 		{
-			name:    "Positive_SuccessfulUpdate",
-			wantErr: false,
+			name:        "Positive_FoundOrder",
+			orderNumber: orderNumber,
+			userID:      userID,
+			errType:     nil,
+		},
+		{
+			name:        "Positive_FilteredBySum",
+			orderNumber: orderNumber,
+			userID:      userID,
+			filter:      WithdrawalFilter{MinSum: &sum, MaxSum: &sum},
+			errType:     nil,
+		},
+		{
+			name:        "Negative_NoWithdrawalsInRange",
+			orderNumber: "",
+			userID:      userID,
+			filter:      WithdrawalFilter{ProcessedFrom: time.Now().Add(time.Hour)},
+			errType:     ErrNoWithdrawalsFound,
+		},
+		{
+			name:        "Negative_NoWithdrawalsFound",
+			orderNumber: "",
+			userID:      userID + 1,
+			errType:     ErrNoWithdrawalsFound,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
-			err := storage.UpdateOrderStatus(ctx, order)
-			assert.Equal(t, tt.wantErr, err != nil)
+			gotWithdrawals, _, err := storage.GetUserWithdrawals(ctx, tt.userID, tt.filter)
+			var gotOrderNumber string
+			if len(gotWithdrawals) > 0 {
+				gotOrderNumber = gotWithdrawals[0].OrderNumber
+			}
+			assert.Equal(t, tt.orderNumber, gotOrderNumber)
+			assert.Equal(t, tt.errType, err)
+		})
+	}
+}
+
+func TestDBStorage_GetUserWithdrawalsSummary(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, orderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum64, err := strconv.ParseFloat(random.DigitString(1, 3), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := float32(sum64)
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: sum, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.WithdrawFromUserBalance(dbCtx, orderNumber, sum, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		userID int
+		filter WithdrawalFilter
+		want   WithdrawalSummary
+	}{
+		{
+			name:   "Positive_AllWithdrawals",
+			userID: userID,
+			want:   WithdrawalSummary{Count: 1, Total: sum},
+		},
+		{
+			name:   "Positive_NoWithdrawalsInRange",
+			userID: userID,
+			filter: WithdrawalFilter{ProcessedTo: time.Now().Add(-time.Hour)},
+			want:   WithdrawalSummary{Count: 0, Total: 0},
+		},
+		{
+			name:   "Positive_NoWithdrawals",
+			userID: userID + 1,
+			want:   WithdrawalSummary{Count: 0, Total: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got, err := storage.GetUserWithdrawalsSummary(ctx, tt.userID, tt.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDBStorage_ReserveIdempotencyKey(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	completedKey := random.ASCIIString(16, 32)
+	_, err = storage.ReserveIdempotencyKey(dbCtx, completedKey, "hash", userID, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := IdempotentResponse{StatusCode: 200, ContentType: "application/json", Body: []byte("{}")}
+	err = storage.SaveIdempotentResponse(dbCtx, completedKey, userID, response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pendingKey := random.ASCIIString(16, 32)
+	_, err = storage.ReserveIdempotencyKey(dbCtx, pendingKey, "hash", userID, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		hash     string
+		response *IdempotentResponse
+		errType  error
+	}{
+		{
+			name:     "Positive_NewKey",
+			key:      random.ASCIIString(16, 32),
+			hash:     "hash",
+			response: nil,
+			errType:  nil,
+		},
+		{
+			name:     "Positive_ReplayedResponse",
+			key:      completedKey,
+			hash:     "hash",
+			response: &response,
+			errType:  nil,
+		},
+		{
+			name:    "Negative_KeyReused",
+			key:     completedKey,
+			hash:    "other hash",
+			errType: ErrIdempotencyKeyReused,
+		},
+		{
+			name:    "Negative_KeyInProgress",
+			key:     pendingKey,
+			hash:    "hash",
+			errType: ErrIdempotencyKeyInProgress,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got, err := storage.ReserveIdempotencyKey(ctx, tt.key, tt.hash, userID, time.Minute)
+			assert.Equal(t, tt.errType, err)
+			assert.Equal(t, tt.response, got)
+		})
+	}
+
+	_, err = dbPool.Exec(dbCtx, "UPDATE public.idempotency_key SET expires_at = current_timestamp - interval '1 second' WHERE user_id = $1 AND key = $2", userID, completedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := storage.DeleteExpiredIdempotencyKeys(dbCtx)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, deleted, int64(1))
+	_, err = storage.ReserveIdempotencyKey(dbCtx, pendingKey, "hash", userID, time.Minute)
+	assert.Equal(t, ErrIdempotencyKeyInProgress, err, "keys which have not expired are kept")
+}
+
+func TestDBStorage_ClawbackOrderAccrual(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processedOrderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, processedOrderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: processedOrderNumber, Status: "PROCESSED", Accrual: 100, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.WithdrawFromUserBalance(dbCtx, goluhn.Generate(8), 60, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newOrderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, newOrderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		orderNumber string
+		sum         float32
+		want        Balance
+		errType     error
+	}{
+		{
+			name:        "Positive_PartialClawback",
+			orderNumber: processedOrderNumber,
+			sum:         30,
+			want:        Balance{Current: 10, Withdrawn: 60},
+			errType:     nil,
+		},
+		{
+			name:        "Positive_FullClawbackWithDebt",
+			orderNumber: processedOrderNumber,
+			sum:         0,
+			want:        Balance{Current: 0, Withdrawn: 60, Debt: 60},
+			errType:     nil,
+		},
+		{
+			name:        "Negative_OrderRevoked",
+			orderNumber: processedOrderNumber,
+			sum:         0,
+			want:        Balance{Current: 0, Withdrawn: 60, Debt: 60},
+			errType:     ErrOrderNotProcessed,
+		},
+		{
+			name:        "Negative_OrderNotProcessed",
+			orderNumber: newOrderNumber,
+			sum:         10,
+			want:        Balance{Current: 0, Withdrawn: 60, Debt: 60},
+			errType:     ErrOrderNotProcessed,
+		},
+		{
+			name:        "Negative_OrderNotFound",
+			orderNumber: newOrderNumber + "0",
+			sum:         10,
+			want:        Balance{Current: 0, Withdrawn: 60, Debt: 60},
+			errType:     ErrOrderNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := storage.ClawbackOrderAccrual(ctx, tt.orderNumber, tt.sum, "order returned")
+			assert.Equal(t, tt.errType, err)
+			balance, err := storage.GetUserBalance(ctx, userID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, balance)
 		})
 	}
+
+	order, err := storage.GetUserOrder(dbCtx, processedOrderNumber, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, "REVOKED", order.Status)
+
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: newOrderNumber, Status: "PROCESSED", Accrual: 100, UserID: userID})
+	assert.NoError(t, err)
+	balance, err := storage.GetUserBalance(dbCtx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, Balance{Current: 40, Withdrawn: 60}, balance)
+}
+
+func TestDBStorage_Holds(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, orderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: 100, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	captured, err := storage.CreateHold(dbCtx, goluhn.Generate(8), 30, time.Minute, userID)
+	assert.NoError(t, err)
+	released, err := storage.CreateHold(dbCtx, goluhn.Generate(8), 20, time.Minute, userID)
+	assert.NoError(t, err)
+	_, err = storage.CreateHold(dbCtx, goluhn.Generate(8), 100, time.Minute, userID)
+	assert.Equal(t, ErrInsufficientFunds, err)
+
+	tests := []struct {
+		name    string
+		resolve func(context.Context, int, int) (Hold, error)
+		holdID  int
+		userID  int
+		want    Balance
+		errType error
+	}{
+		{
+			name:    "Negative_HoldNotFound",
+			resolve: storage.CaptureHold,
+			holdID:  captured.ID,
+			userID:  userID + 1,
+			want:    Balance{Current: 50, Withdrawn: 0, Held: 50},
+			errType: ErrHoldNotFound,
+		},
+		{
+			name:    "Positive_Captured",
+			resolve: storage.CaptureHold,
+			holdID:  captured.ID,
+			userID:  userID,
+			want:    Balance{Current: 50, Withdrawn: 30, Held: 20},
+			errType: nil,
+		},
+		{
+			name:    "Negative_AlreadyCaptured",
+			resolve: storage.ReleaseHold,
+			holdID:  captured.ID,
+			userID:  userID,
+			want:    Balance{Current: 50, Withdrawn: 30, Held: 20},
+			errType: ErrHoldNotActive,
+		},
+		{
+			name:    "Positive_Released",
+			resolve: storage.ReleaseHold,
+			holdID:  released.ID,
+			userID:  userID,
+			want:    Balance{Current: 70, Withdrawn: 30, Held: 0},
+			errType: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := tt.resolve(ctx, tt.holdID, tt.userID)
+			assert.Equal(t, tt.errType, err)
+			balance, err := storage.GetUserBalance(ctx, userID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, balance)
+		})
+	}
+
+	_, err = storage.CreateHold(dbCtx, goluhn.Generate(8), 70, 0, userID)
+	assert.NoError(t, err)
+	count, err := storage.ReleaseExpiredHolds(dbCtx)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, count, 1)
+	balance, err := storage.GetUserBalance(dbCtx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, Balance{Current: 70, Withdrawn: 30, Held: 0}, balance)
+}
+
+func TestDBStorage_ExpirePoints(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.SetExpirationPolicy(dbCtx, ExpirationPolicy{Months: 1, Notice: 60 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldOrderNumber := goluhn.Generate(8)
+	newOrderNumber := goluhn.Generate(8)
+	for orderNumber, accrual := range map[string]float32{oldOrderNumber: 100, newOrderNumber: 50} {
+		err = storage.InsertNewOrder(dbCtx, orderNumber, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: accrual, UserID: userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = storage.WithdrawFromUserBalance(dbCtx, goluhn.Generate(8), 30, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dbPool.Exec(dbCtx, "UPDATE public.accrual_lot SET expires_at = current_timestamp - interval '1 second' WHERE order_number = $1", oldOrderNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := storage.ExpirePoints(dbCtx)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, expired, 1)
+
+	balance, err := storage.GetUserBalance(dbCtx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(50), balance.Current)
+	if assert.Len(t, balance.Expiring, 1) {
+		assert.Equal(t, float32(50), balance.Expiring[0].Sum)
+	}
+
+	events, _, err := storage.GetAuditEvents(dbCtx, AuditFilter{UserID: &userID, Type: AuditPointsExpired})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.JSONEq(t, `{"current": 50, "withdrawn": 30, "held": 0, "debt": 0}`, extractAuditBalance(t, events[0].After))
+	}
+}
+
+func TestDBStorage_UpdateOrderStatus(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, orderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum64, err := strconv.ParseFloat(random.DigitString(1, 3), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := float32(sum64)
+
+	order := Order{
+		Number:  orderNumber,
+		Status:  "PROCESSED",
+		Accrual: sum,
+		UserID:  userID,
+	}
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{
+			name:    "Positive_SuccessfulUpdate",
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := storage.UpdateOrderStatus(ctx, order)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestDBStorage_Tiers(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.GetUserTier(dbCtx, 0)
+	assert.ErrorIs(t, err, ErrTiersDisabled)
+
+	tiers, err := ParseTiers("BRONZE:0:1,SILVER:100:1.5,GOLD:1000000:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(t, storage.SetTierPolicy(TierPolicy{Basis: "unknown", Tiers: tiers}), ErrUnknownTierBasis)
+	err = storage.SetTierPolicy(TierPolicy{Basis: TierBasisAccrued, Tiers: tiers})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstOrderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, firstOrderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: firstOrderNumber, Status: "PROCESSED", Accrual: 150, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err := storage.GetUserTier(dbCtx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, "BRONZE", progress.Tier.Name)
+	assert.Equal(t, float32(150), progress.Points)
+
+	_, err = storage.RecalculateTiers(dbCtx)
+	assert.NoError(t, err)
+
+	progress, err = storage.GetUserTier(dbCtx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, "SILVER", progress.Tier.Name)
+	if assert.NotNil(t, progress.Next) {
+		assert.Equal(t, "GOLD", progress.Next.Name)
+	}
+
+	secondOrderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, secondOrderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: secondOrderNumber, Status: "PROCESSED", Accrual: 100, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := storage.GetUserOrder(dbCtx, secondOrderNumber, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(50), order.TierBonus)
+
+	balance, err := storage.GetUserBalance(dbCtx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(300), balance.Current)
+}
+
+func TestDBStorage_Campaigns(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	budget := float32(150)
+	campaign := Campaign{
+		Name:       random.ASCIIString(4, 10),
+		Kind:       CampaignKindFixed,
+		Value:      100,
+		StartsAt:   time.Now().Add(-time.Minute),
+		EndsAt:     time.Now().Add(time.Minute),
+		MinAccrual: 900000,
+		Budget:     &budget,
+	}
+
+	_, err = storage.CreateCampaign(dbCtx, Campaign{Name: campaign.Name, Kind: CampaignKindMultiplier, Value: 1, StartsAt: campaign.StartsAt, EndsAt: campaign.EndsAt})
+	assert.ErrorIs(t, err, ErrWrongCampaignSettings)
+
+	campaign, err = storage.CreateCampaign(dbCtx, campaign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		campaign.EndsAt = time.Now()
+		campaign.StartsAt = campaign.EndsAt.Add(-time.Minute)
+		storage.UpdateCampaign(context.Background(), campaign)
+	}()
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		accrual   float32
+		wantBonus float32
+	}{
+		{
+			name:      "Positive_FullBonus",
+			accrual:   900000,
+			wantBonus: 100,
+		},
+		{
+			name:      "Positive_BudgetCapped",
+			accrual:   900000,
+			wantBonus: 50,
+		},
+		{
+			name:      "Positive_BudgetExhausted",
+			accrual:   900000,
+			wantBonus: 0,
+		},
+		{
+			name:      "Negative_NotEligible",
+			accrual:   10,
+			wantBonus: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			orderNumber := goluhn.Generate(8)
+			err := storage.InsertNewOrder(ctx, orderNumber, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = storage.UpdateOrderStatus(ctx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: tt.accrual, UserID: userID})
+			assert.NoError(t, err)
+
+			order, err := storage.GetUserOrder(ctx, orderNumber, userID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantBonus, order.Bonus)
+		})
+	}
+
+	campaign, err = storage.GetCampaign(dbCtx, campaign.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, budget, campaign.Spent)
+
+	assert.ErrorIs(t, storage.DeleteCampaign(dbCtx, campaign.ID), ErrCampaignInUse)
+	_, err = storage.GetCampaign(dbCtx, -1)
+	assert.ErrorIs(t, err, ErrCampaignNotFound)
+}
+
+func TestDBStorage_Referrals(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.SetReferralPolicy(ReferralPolicy{ReferrerBonus: 100, RefereeBonus: 50, MonthlyLimit: 1, MinAccrual: 10})
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	referrerLogin := random.ASCIIString(4, 10)
+	err = storage.CreateUser(dbCtx, referrerLogin, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrerID, _, err := storage.GetUserCredentials(dbCtx, referrerLogin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrals, err := storage.GetUserReferrals(dbCtx, referrerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.CreateReferredUser(dbCtx, random.ASCIIString(4, 10), string(hashedPassword), "unknown code")
+	assert.ErrorIs(t, err, ErrUnknownReferralCode)
+
+	tests := []struct {
+		name       string
+		accrual    float32
+		wantStatus string
+		wantBonus  float32
+	}{
+		{
+			name:       "Negative_AccrualTooSmall",
+			accrual:    5,
+			wantStatus: ReferralStatusRejected,
+			wantBonus:  5,
+		},
+		{
+			name:       "Positive_Rewarded",
+			accrual:    20,
+			wantStatus: ReferralStatusRewarded,
+			wantBonus:  70,
+		},
+		{
+			name:       "Negative_MonthlyLimit",
+			accrual:    20,
+			wantStatus: ReferralStatusRejected,
+			wantBonus:  20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			login := random.ASCIIString(4, 10)
+			err := storage.CreateReferredUser(ctx, login, string(hashedPassword), strings.ToLower(referrals.Code))
+			if err != nil {
+				t.Fatal(err)
+			}
+			userID, _, err := storage.GetUserCredentials(ctx, login)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			orderNumber := goluhn.Generate(8)
+			err = storage.InsertNewOrder(ctx, orderNumber, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = storage.UpdateOrderStatus(ctx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: tt.accrual, UserID: userID})
+			assert.NoError(t, err)
+
+			balance, err := storage.GetUserBalance(ctx, userID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantBonus, balance.Current)
+
+			referrals, err := storage.GetUserReferrals(ctx, referrerID)
+			assert.NoError(t, err)
+			if assert.NotEmpty(t, referrals.Referrals) {
+				assert.Equal(t, login, referrals.Referrals[0].Login)
+				assert.Equal(t, tt.wantStatus, referrals.Referrals[0].Status)
+			}
+		})
+	}
+
+	balance, err := storage.GetUserBalance(dbCtx, referrerID)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(100), balance.Current)
+}
+
+func TestDBStorage_TransferPoints(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.SetTransferPolicy(TransferPolicy{DailySum: 100, DailyCount: 2})
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senderLogin, recipientLogin := random.ASCIIString(4, 10), random.ASCIIString(4, 10)
+	for _, login := range []string{senderLogin, recipientLogin} {
+		err = storage.CreateUser(dbCtx, login, string(hashedPassword))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	senderID, _, err := storage.GetUserCredentials(dbCtx, senderLogin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientID, _, err := storage.GetUserCredentials(dbCtx, recipientLogin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, orderNumber, senderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: 150, UserID: senderID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		toLogin string
+		sum     float32
+		errType error
+	}{
+		{
+			name:    "Positive_Transfer",
+			toLogin: recipientLogin,
+			sum:     60,
+			errType: nil,
+		},
+		{
+			name:    "Negative_UnknownRecipient",
+			toLogin: random.ASCIIString(11, 16),
+			sum:     10,
+			errType: ErrRecipientNotFound,
+		},
+		{
+			name:    "Negative_Self",
+			toLogin: senderLogin,
+			sum:     10,
+			errType: ErrTransferToSelf,
+		},
+		{
+			name:    "Negative_DailySumExceeded",
+			toLogin: recipientLogin,
+			sum:     50,
+			errType: ErrTransferDailyLimitExceeded,
+		},
+		{
+			name:    "Positive_SecondTransferIgnoringCase",
+			toLogin: strings.ToUpper(recipientLogin),
+			sum:     40,
+			errType: nil,
+		},
+		{
+			name:    "Negative_DailyCountExceeded",
+			toLogin: recipientLogin,
+			sum:     1,
+			errType: ErrTransferDailyLimitExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			transfer, err := storage.TransferPoints(ctx, tt.toLogin, tt.sum, senderID)
+			assert.ErrorIs(t, err, tt.errType)
+			if tt.errType == nil {
+				assert.Equal(t, "out", transfer.Direction)
+				assert.Equal(t, tt.sum, transfer.Sum)
+				assert.Equal(t, recipientLogin, transfer.Counterparty, "the stored login is returned whatever the case of the input")
+			}
+		})
+	}
+
+	storage.SetTransferPolicy(TransferPolicy{})
+	_, err = storage.TransferPoints(dbCtx, recipientLogin, 100, senderID)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	balance, err := storage.GetUserBalance(dbCtx, recipientID)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(100), balance.Current)
+
+	transfers, _, err := storage.GetUserTransfers(dbCtx, recipientID, Page{})
+	assert.NoError(t, err)
+	if assert.Len(t, transfers, 2) {
+		assert.Equal(t, "in", transfers[0].Direction)
+		assert.Equal(t, senderLogin, transfers[0].Counterparty)
+	}
+}
+
+func TestDBStorage_AuditEvents(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(random.ASCIIString(16, 32)), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auditCtx := WithAuditMeta(dbCtx, &AuditMeta{Actor: login, IP: "192.0.2.1", UserAgent: "test"})
+	err = storage.CreateUser(auditCtx, login, string(hashedPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, orderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: 100, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.WithdrawFromUserBalance(auditCtx, goluhn.Generate(8), 40, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, _, err := storage.GetAuditEvents(dbCtx, AuditFilter{UserID: &userID})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, AuditUserRegistered, events[0].Type)
+		assert.Equal(t, "192.0.2.1", events[0].IP)
+		assert.Equal(t, AuditOrderStatusChanged, events[1].Type)
+		assert.Equal(t, "system", events[1].Actor)
+		assert.Equal(t, AuditWithdrawal, events[2].Type)
+		assert.Equal(t, login, events[2].Actor)
+		assert.JSONEq(t, `{"current": 60, "withdrawn": 40, "held": 0, "debt": 0}`, extractAuditBalance(t, events[2].After))
+	}
+
+	events, _, err = storage.GetAuditEvents(dbCtx, AuditFilter{UserID: &userID, Type: AuditWithdrawal, From: time.Now().Add(time.Hour)})
+	assert.ErrorIs(t, err, ErrNoAuditEventsFound)
+	assert.Empty(t, events)
+
+	_, err = dbPool.Exec(dbCtx, "UPDATE public.audit_event SET actor = 'nobody' WHERE user_id = $1", userID)
+	assert.Error(t, err)
+	_, err = dbPool.Exec(dbCtx, "DELETE FROM public.audit_event WHERE user_id = $1", userID)
+	assert.Error(t, err)
+}
+
+func extractAuditBalance(t *testing.T, data []byte) string {
+	var value struct {
+		Balance json.RawMessage `json:"balance"`
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	return string(value.Balance)
+}
+
+func TestDBStorage_APIKeys(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	err = storage.CreateUser(dbCtx, login, random.ASCIIString(16, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.CreateAPIKey(dbCtx, userID, " ", []string{ScopeOrdersRead})
+	assert.ErrorIs(t, err, ErrWrongAPIKeyScopes)
+	_, err = storage.CreateAPIKey(dbCtx, userID, "partner", []string{"orders:delete"})
+	assert.ErrorIs(t, err, ErrWrongAPIKeyScopes)
+
+	key, err := storage.CreateAPIKey(dbCtx, userID, "partner", []string{ScopeOrdersRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, key.Token)
+	assert.Nil(t, key.LastUsedAt)
+
+	keyUserID, keyLogin, authenticated, err := storage.AuthenticateAPIKey(dbCtx, key.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, userID, keyUserID)
+	assert.Equal(t, login, keyLogin)
+	assert.True(t, authenticated.HasScope(ScopeOrdersRead))
+	assert.False(t, authenticated.HasScope(ScopeWithdraw))
+	assert.NotNil(t, authenticated.LastUsedAt)
+
+	_, _, _, err = storage.AuthenticateAPIKey(dbCtx, key.Token+"x")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	keys, err := storage.GetAPIKeys(dbCtx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, keys, 1) {
+		assert.Empty(t, keys[0].Token)
+	}
+
+	assert.NoError(t, storage.RevokeAPIKey(dbCtx, userID, key.ID))
+	assert.ErrorIs(t, storage.RevokeAPIKey(dbCtx, userID, key.ID), ErrAPIKeyNotFound)
+
+	_, _, _, err = storage.AuthenticateAPIKey(dbCtx, key.Token)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	key, err = storage.CreateAPIKey(dbCtx, userID, "partner", []string{ScopeOrdersRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, storage.ChangePassword(dbCtx, userID, random.ASCIIString(16, 32)))
+	_, _, _, err = storage.AuthenticateAPIKey(dbCtx, key.Token)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey, "a password change revokes the keys")
+}
+
+func TestDBStorage_UserEvents(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	err = storage.CreateUser(dbCtx, login, random.ASCIIString(16, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastID, err := storage.GetLastUserEventID(dbCtx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Zero(t, lastID)
+
+	notified := make(chan int, 10)
+	listenCtx, stopListening := context.WithCancel(dbCtx)
+	listening := make(chan error, 1)
+	go func() {
+		listening <- storage.ListenUserEvents(listenCtx, func(userID int) { notified <- userID })
+	}()
+	// The listener has to be connected before the events are committed.
+	time.Sleep(100 * time.Millisecond)
+
+	orderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, orderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: 500, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case notifiedUserID := <-notified:
+		assert.Equal(t, userID, notifiedUserID)
+	case <-time.After(time.Second):
+		t.Error("no notification received")
+	}
+	stopListening()
+	assert.Error(t, <-listening)
+
+	events, err := storage.GetUserEvents(dbCtx, userID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, events, 2, "one event per transaction and type") {
+		assert.Equal(t, EventOrderStatus, events[0].Type)
+		assert.JSONEq(t, `{"number": "`+orderNumber+`", "status": "PROCESSED", "accrual": 500, "tier_bonus": 0, "bonus": 0}`, string(events[0].Data))
+		assert.Equal(t, EventBalance, events[1].Type)
+		assert.JSONEq(t, `{"current": 500, "withdrawn": 0, "held": 0, "debt": 0}`, string(events[1].Data))
+
+		resumed, err := storage.GetUserEvents(dbCtx, userID, events[0].ID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, events[1:], resumed)
+
+		lastID, err = storage.GetLastUserEventID(dbCtx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, events[1].ID, lastID)
+	}
+
+	_, err = storage.DeleteUserEvents(dbCtx, time.Hour)
+	assert.NoError(t, err)
+	events, err = storage.GetUserEvents(dbCtx, userID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, events, 2, "recent events are kept")
+}
+
+func TestDBStorage_GetLoginLock(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.SetLockoutPolicy(LockoutPolicy{MaxFailures: 2, Lockout: time.Minute, Window: time.Minute})
+
+	login := random.ASCIIString(4, 10)
+	ip := "192.0.2." + strconv.Itoa(len(login))
+
+	retryAfter, failures, err := storage.GetLoginLock(dbCtx, login, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Zero(t, retryAfter)
+	assert.Zero(t, failures, "nothing to reset for a login without failures")
+
+	_, _, err = storage.RegisterLoginFailure(dbCtx, login, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retryAfter, failures, err = storage.GetLoginLock(dbCtx, login, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Zero(t, retryAfter)
+	assert.Equal(t, 1, failures)
+
+	_, err = dbPool.Exec(dbCtx, "UPDATE public.login_failure SET last_failure_at = current_timestamp - interval '2 minutes' WHERE key = $1", ipKey(ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := storage.DeleteExpiredLoginFailures(dbCtx)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, deleted, int64(1))
+	_, failures, err = storage.GetLoginLock(dbCtx, login, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, failures, "failures within the window are kept")
+
+	assert.NoError(t, storage.ResetLoginFailures(dbCtx, login))
+	_, failures, err = storage.GetLoginLock(dbCtx, login, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Zero(t, failures)
+}
+
+func TestDBStorage_CreditSettlesDebt(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newUser := func() int {
+		login := random.ASCIIString(4, 10)
+		if err := storage.CreateUser(dbCtx, login, random.ASCIIString(16, 32)); err != nil {
+			t.Fatal(err)
+		}
+		userID, _, err := storage.GetUserCredentials(dbCtx, login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return userID
+	}
+	accrue := func(userID int, sum float32) string {
+		orderNumber := goluhn.Generate(8)
+		if err := storage.InsertNewOrder(dbCtx, orderNumber, userID); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: sum, UserID: userID}); err != nil {
+			t.Fatal(err)
+		}
+		return orderNumber
+	}
+
+	// A reversed withdrawal pays the debt left by a clawback first.
+	userID := newUser()
+	orderNumber := accrue(userID, 100)
+	if err = storage.WithdrawFromUserBalance(dbCtx, goluhn.Generate(8), 100, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.ClawbackOrderAccrual(dbCtx, orderNumber, 60, "order returned"); err != nil {
+		t.Fatal(err)
+	}
+	withdrawals, _, err := storage.GetUserWithdrawals(dbCtx, userID, WithdrawalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, storage.ReverseWithdrawal(dbCtx, withdrawals[0].ID, "refund"))
+	balance, err := storage.GetUserBalance(dbCtx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, Balance{Current: 40}, balance)
+
+	// The partial policy keeps the held part of the shortfall as debt.
+	assert.NoError(t, storage.SetClawbackPolicy(ClawbackPolicyPartial))
+	userID = newUser()
+	orderNumber = accrue(userID, 100)
+	hold, err := storage.CreateHold(dbCtx, goluhn.Generate(8), 80, time.Minute, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clawback, err := storage.ClawbackOrderAccrual(dbCtx, orderNumber, 0, "order returned")
+	assert.NoError(t, err)
+	assert.Equal(t, float32(20), clawback.Recovered)
+	assert.Equal(t, float32(80), clawback.Debt)
+	balance, err = storage.GetUserBalance(dbCtx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, Balance{Held: 80, Debt: 80}, balance)
+
+	// Released funds settle the debt before going back to the balance.
+	_, err = storage.ReleaseHold(dbCtx, hold.ID, userID)
+	assert.NoError(t, err)
+	balance, err = storage.GetUserBalance(dbCtx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, Balance{}, balance)
+}
+
+func TestDBStorage_SetExpirationPolicy(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := random.ASCIIString(4, 10)
+	err = storage.CreateUser(dbCtx, login, random.ASCIIString(16, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, _, err := storage.GetUserCredentials(dbCtx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The balance is accrued before the lots are tracked.
+	orderNumber := goluhn.Generate(8)
+	err = storage.InsertNewOrder(dbCtx, orderNumber, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: 40, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, storage.SetExpirationPolicy(dbCtx, ExpirationPolicy{Months: 1, Notice: 60 * 24 * time.Hour}))
+	assert.NoError(t, storage.SetExpirationPolicy(dbCtx, ExpirationPolicy{Months: 1, Notice: 60 * 24 * time.Hour}), "the backfill runs once")
+
+	balance, err := storage.GetUserBalance(dbCtx, userID)
+	assert.NoError(t, err)
+	if assert.Len(t, balance.Expiring, 1) {
+		assert.Equal(t, float32(40), balance.Expiring[0].Sum)
+	}
+}
+
+func TestDBStorage_AuditEvents_BalanceChanges(t *testing.T) {
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dbPool, err := pgxpool.New(dbCtx, cfg.DatabaseURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	storage, err := NewDBStorage(dbCtx, dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logins := []string{random.ASCIIString(4, 10), random.ASCIIString(4, 10)}
+	userIDs := make([]int, len(logins))
+	for i, login := range logins {
+		if err = storage.CreateUser(dbCtx, login, random.ASCIIString(16, 32)); err != nil {
+			t.Fatal(err)
+		}
+		if userIDs[i], _, err = storage.GetUserCredentials(dbCtx, login); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orderNumber := goluhn.Generate(8)
+	if err = storage.InsertNewOrder(dbCtx, orderNumber, userIDs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.UpdateOrderStatus(dbCtx, Order{Number: orderNumber, Status: "PROCESSED", Accrual: 100, UserID: userIDs[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.TransferPoints(dbCtx, logins[1], 30, userIDs[0])
+	assert.NoError(t, err)
+	hold, err := storage.CreateHold(dbCtx, goluhn.Generate(8), 20, time.Minute, userIDs[0])
+	assert.NoError(t, err)
+	_, err = storage.ReleaseHold(dbCtx, hold.ID, userIDs[0])
+	assert.NoError(t, err)
+	_, err = storage.ClawbackOrderAccrual(dbCtx, orderNumber, 10, "order returned")
+	assert.NoError(t, err)
+	assert.NoError(t, storage.SetUserRole(dbCtx, userIDs[1], RoleSupport))
+
+	tests := []struct {
+		name      string
+		userID    int
+		eventType string
+		after     string
+	}{
+		{
+			name:      "Positive_TransferSender",
+			userID:    userIDs[0],
+			eventType: AuditTransfer,
+			after:     `{"current": 70, "withdrawn": 0, "held": 0, "debt": 0}`,
+		},
+		{
+			name:      "Positive_TransferRecipient",
+			userID:    userIDs[1],
+			eventType: AuditTransfer,
+			after:     `{"current": 30, "withdrawn": 0, "held": 0, "debt": 0}`,
+		},
+		{
+			name:      "Positive_HoldCreated",
+			userID:    userIDs[0],
+			eventType: AuditHoldCreated,
+			after:     `{"current": 50, "withdrawn": 0, "held": 20, "debt": 0}`,
+		},
+		{
+			name:      "Positive_HoldReleased",
+			userID:    userIDs[0],
+			eventType: AuditHoldReleased,
+			after:     `{"current": 70, "withdrawn": 0, "held": 0, "debt": 0}`,
+		},
+		{
+			name:      "Positive_Clawback",
+			userID:    userIDs[0],
+			eventType: AuditClawback,
+			after:     `{"current": 60, "withdrawn": 0, "held": 0, "debt": 0}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := tt.userID
+			events, _, err := storage.GetAuditEvents(dbCtx, AuditFilter{UserID: &userID, Type: tt.eventType})
+			assert.NoError(t, err)
+			if assert.Len(t, events, 1) {
+				assert.JSONEq(t, tt.after, extractAuditBalance(t, events[0].After))
+			}
+		})
+	}
+
+	events, _, err := storage.GetAuditEvents(dbCtx, AuditFilter{UserID: &userIDs[1], Type: AuditRoleChanged})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.JSONEq(t, `{"role": "support"}`, string(events[0].After))
+	}
 }
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	TierBasisAccrued = "accrued"
+	TierBasisSpent   = "spent"
+)
+
+var (
+	ErrWrongTierFormat  = errors.New("wrong loyalty tier format")
+	ErrUnknownTierBasis = errors.New("unknown loyalty tier basis")
+	ErrTiersDisabled    = errors.New("loyalty tiers are disabled")
+)
+
+type Tier struct {
+	Name       string  `json:"name"`
+	Threshold  float32 `json:"threshold"`
+	Multiplier float32 `json:"multiplier"`
+}
+
+type TierPolicy struct {
+	Basis string
+	Tiers []Tier
+}
+
+func ParseTiers(s string) (tiers []Tier, err error) {
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			return nil, ErrWrongTierFormat
+		}
+		threshold, err := strconv.ParseFloat(parts[1], 32)
+		if err != nil || threshold < 0 {
+			return nil, ErrWrongTierFormat
+		}
+		multiplier, err := strconv.ParseFloat(parts[2], 32)
+		if err != nil || multiplier <= 0 {
+			return nil, ErrWrongTierFormat
+		}
+		tiers = append(tiers, Tier{
+			Name:       strings.ToUpper(strings.TrimSpace(parts[0])),
+			Threshold:  float32(threshold),
+			Multiplier: float32(multiplier),
+		})
+	}
+
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].Threshold < tiers[j].Threshold
+	})
+	if len(tiers) > 0 && tiers[0].Threshold != 0 {
+		return nil, ErrWrongTierFormat
+	}
+
+	return tiers, nil
+}
+
+func (s *DBStorage) SetTierPolicy(policy TierPolicy) (err error) {
+	switch policy.Basis {
+	case TierBasisAccrued, TierBasisSpent:
+	default:
+		return ErrUnknownTierBasis
+	}
+	s.tierPolicy = policy
+
+	return nil
+}
+
+func (s DBStorage) tierMultiplier(name string) float32 {
+	for _, tier := range s.tierPolicy.Tiers {
+		if tier.Name == name {
+			return tier.Multiplier
+		}
+	}
+
+	return 1
+}
+
+func (s DBStorage) tierMetricQuery() string {
+	if s.tierPolicy.Basis == TierBasisSpent {
+		return "SELECT coalesce(sum(wd.sum), 0) FROM public.withdrawal wd WHERE wd.user_id = u.id AND wd.processed_at > current_timestamp - interval '12 months'"
+	}
+	return "SELECT coalesce(sum(o.accrual + o.tier_bonus + o.bonus), 0) FROM public.order o WHERE o.user_id = u.id AND o.status = 'PROCESSED' AND o.status_changed_at > current_timestamp - interval '12 months'"
+}
+
+func (s DBStorage) RecalculateTiers(ctx context.Context) (updated int64, err error) {
+	if len(s.tierPolicy.Tiers) == 0 {
+		return 0, nil
+	}
+
+	args := make([]any, 0, 2*len(s.tierPolicy.Tiers))
+	tierCase := "CASE"
+	for i := len(s.tierPolicy.Tiers) - 1; i > 0; i-- {
+		args = append(args, s.tierPolicy.Tiers[i].Threshold, s.tierPolicy.Tiers[i].Name)
+		tierCase += " WHEN m.points >= $" + strconv.Itoa(len(args)-1) + " THEN $" + strconv.Itoa(len(args))
+	}
+	args = append(args, s.tierPolicy.Tiers[0].Name)
+	tierCase += " ELSE $" + strconv.Itoa(len(args)) + " END"
+
+	tag, err := s.pool.Exec(ctx, "UPDATE public.user u SET tier = "+tierCase+" FROM (SELECT u.id, ("+s.tierMetricQuery()+") AS points FROM public.user u) m WHERE u.id = m.id AND u.tier IS DISTINCT FROM "+tierCase, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (s DBStorage) GetUserTier(ctx context.Context, userID int) (progress TierProgress, err error) {
+	if len(s.tierPolicy.Tiers) == 0 {
+		return progress, ErrTiersDisabled
+	}
+
+	var name string
+	err = s.pool.QueryRow(ctx, "SELECT u.tier, ("+s.tierMetricQuery()+") FROM public.user u WHERE u.id = $1", userID).Scan(&name, &progress.Points)
+	if err != nil {
+		return progress, err
+	}
+
+	progress.Basis = s.tierPolicy.Basis
+	current := 0
+	for i, tier := range s.tierPolicy.Tiers {
+		if tier.Name == name {
+			current = i
+		}
+	}
+	progress.Tier = s.tierPolicy.Tiers[current]
+	if current+1 < len(s.tierPolicy.Tiers) {
+		next := s.tierPolicy.Tiers[current+1]
+		progress.Next = &next
+		progress.Remaining = next.Threshold - progress.Points
+		if progress.Remaining < 0 {
+			progress.Remaining = 0
+		}
+	}
+
+	return progress, nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		tiers   string
+		want    []Tier
+		errType error
+	}{
+		{
+			name:  "Positive_Sorted",
+			tiers: "gold:5000:1.5, BRONZE:0:1,SILVER:1000:1.25",
+			want: []Tier{
+				{Name: "BRONZE", Threshold: 0, Multiplier: 1},
+				{Name: "SILVER", Threshold: 1000, Multiplier: 1.25},
+				{Name: "GOLD", Threshold: 5000, Multiplier: 1.5},
+			},
+			errType: nil,
+		},
+		{
+			name:    "Positive_Empty",
+			tiers:   "",
+			want:    nil,
+			errType: nil,
+		},
+		{
+			name:    "Negative_NoBaseTier",
+			tiers:   "SILVER:1000:1.25",
+			errType: ErrWrongTierFormat,
+		},
+		{
+			name:    "Negative_WrongMultiplier",
+			tiers:   "BRONZE:0:0",
+			errType: ErrWrongTierFormat,
+		},
+		{
+			name:    "Negative_WrongFormat",
+			tiers:   "BRONZE:0",
+			errType: ErrWrongTierFormat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiers, err := ParseTiers(tt.tiers)
+			assert.ErrorIs(t, err, tt.errType)
+			if tt.errType == nil {
+				assert.Equal(t, tt.want, tiers)
+			}
+		})
+	}
+}
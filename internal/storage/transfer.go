@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrRecipientNotFound          = errors.New("recipient not found")
+	ErrTransferToSelf             = errors.New("transfer to self is not allowed")
+	ErrTransferDailyLimitExceeded = errors.New("daily transfer limit exceeded")
+	ErrNoTransfersFound           = errors.New("no transfers found")
+)
+
+type TransferPolicy struct {
+	DailySum   float32
+	DailyCount int
+}
+
+func (s *DBStorage) SetTransferPolicy(policy TransferPolicy) {
+	s.transferPolicy = policy
+}
+
+func (s DBStorage) TransferPoints(ctx context.Context, toLogin string, sum float32, userID int) (transfer Transfer, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return transfer, err
+	}
+	defer tx.Rollback(ctx)
+
+	var recipientID int
+	var recipientLogin string
+	err = tx.QueryRow(ctx, "SELECT u.id, u.login FROM public.user u WHERE lower(u.login) = lower($1)", toLogin).Scan(&recipientID, &recipientLogin)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return transfer, ErrRecipientNotFound
+		}
+		return transfer, err
+	}
+	if recipientID == userID {
+		return transfer, ErrTransferToSelf
+	}
+
+	// Both rows are locked in ascending id order so that concurrent transfers
+	// in opposite directions can not deadlock.
+	_, err = tx.Exec(ctx, "SELECT u.id FROM public.user u WHERE u.id IN ($1, $2) ORDER BY u.id FOR UPDATE", userID, recipientID)
+	if err != nil {
+		return transfer, err
+	}
+
+	senderBefore, err := getBalanceSnapshot(ctx, tx, userID)
+	if err != nil {
+		return transfer, err
+	}
+	recipientBefore, err := getBalanceSnapshot(ctx, tx, recipientID)
+	if err != nil {
+		return transfer, err
+	}
+
+	if s.transferPolicy.DailySum > 0 || s.transferPolicy.DailyCount > 0 {
+		var count int
+		var total float32
+		err = tx.QueryRow(ctx, "SELECT count(*), coalesce(sum(t.sum), 0) FROM public.transfer t WHERE t.from_user_id = $1 AND t.created_at >= date_trunc('day', current_timestamp)", userID).Scan(&count, &total)
+		if err != nil {
+			return transfer, err
+		}
+		if (s.transferPolicy.DailyCount > 0 && count >= s.transferPolicy.DailyCount) || (s.transferPolicy.DailySum > 0 && total+sum > s.transferPolicy.DailySum) {
+			return transfer, ErrTransferDailyLimitExceeded
+		}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE public.user SET current = current - $1 WHERE id = $2", sum, userID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.CheckViolation {
+			return transfer, ErrInsufficientFunds
+		}
+		return transfer, err
+	}
+
+	if err = s.consumeLots(ctx, tx, sum, userID); err != nil {
+		return transfer, err
+	}
+
+	credited, err := creditBalance(ctx, tx, recipientID, sum)
+	if err != nil {
+		return transfer, err
+	}
+
+	if err = s.createLot(ctx, tx, "", credited, recipientID); err != nil {
+		return transfer, err
+	}
+
+	err = pgxscan.Get(ctx, tx, &transfer, "INSERT INTO public.transfer (from_user_id, to_user_id, sum) VALUES ($1, $2, $3) RETURNING id, 'out' AS direction, $4::text AS counterparty, sum, created_at", userID, recipientID, sum, recipientLogin)
+	if err != nil {
+		return transfer, err
+	}
+
+	err = recordBalanceChange(ctx, tx, AuditTransfer, userID, senderBefore, nil, map[string]any{"transfer": transfer.ID, "to": recipientID, "sum": -sum})
+	if err != nil {
+		return transfer, err
+	}
+	err = recordBalanceChange(ctx, tx, AuditTransfer, recipientID, recipientBefore, nil, map[string]any{"transfer": transfer.ID, "from": userID, "sum": sum, "credited": credited})
+	if err != nil {
+		return transfer, err
+	}
+
+	return transfer, tx.Commit(ctx)
+}
+
+func (s DBStorage) GetUserTransfers(ctx context.Context, userID int, page Page) (transfers []Transfer, next *Cursor, err error) {
+	qb := queryBuilder{}
+	qb.add("(t.from_user_id = ? OR t.to_user_id = ?)", userID, userID)
+	orderBy := qb.page("t.created_at", "t.id", page)
+
+	err = pgxscan.Select(ctx, s.pool, &transfers, "SELECT t.id, CASE WHEN t.from_user_id = $1 THEN 'out' ELSE 'in' END AS direction, u.login AS counterparty, t.sum, t.created_at FROM public.transfer t JOIN public.user u ON u.id = CASE WHEN t.from_user_id = $1 THEN t.to_user_id ELSE t.from_user_id END"+qb.whereClause()+orderBy, qb.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(transfers) == 0 {
+		return nil, nil, ErrNoTransfersFound
+	}
+
+	if page.Limit > 0 && len(transfers) > page.Limit {
+		transfers = transfers[:page.Limit]
+		last := transfers[len(transfers)-1]
+		next = &Cursor{At: last.CreatedAt, ID: last.ID}
+	}
+
+	return transfers, next, nil
+}
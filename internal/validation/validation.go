@@ -0,0 +1,98 @@
+package validation
+
+import (
+	_ "embed"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	minLoginLength = 3
+	maxLoginLength = 32
+	// bcrypt ignores everything after the first 72 bytes.
+	maxPasswordBytes = 72
+)
+
+var loginPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]struct{} {
+	passwords := make(map[string]struct{})
+	for _, password := range strings.Split(commonPasswordList, "\n") {
+		if password = strings.TrimSpace(password); password != "" {
+			passwords[password] = struct{}{}
+		}
+	}
+	return passwords
+}()
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type PasswordPolicy struct {
+	MinLength      int
+	RequireClasses int
+}
+
+func ValidateLogin(login string) (violations []FieldError) {
+	if length := len(login); length < minLoginLength || length > maxLoginLength {
+		violations = append(violations, FieldError{Field: "login", Message: "must be between " + strconv.Itoa(minLoginLength) + " and " + strconv.Itoa(maxLoginLength) + " characters long"})
+	}
+	if login != "" && !loginPattern.MatchString(login) {
+		violations = append(violations, FieldError{Field: "login", Message: "must start with a letter or a digit and contain only letters, digits, dots, underscores and hyphens"})
+	}
+
+	return violations
+}
+
+func (p PasswordPolicy) Validate(password string, login string) (violations []FieldError) {
+	if password == "" {
+		return []FieldError{{Field: "password", Message: "must not be empty"}}
+	}
+	if length := len([]rune(password)); length < p.MinLength {
+		violations = append(violations, FieldError{Field: "password", Message: "must be at least " + strconv.Itoa(p.MinLength) + " characters long"})
+	}
+	if len(password) > maxPasswordBytes {
+		violations = append(violations, FieldError{Field: "password", Message: "must not be longer than " + strconv.Itoa(maxPasswordBytes) + " bytes"})
+	}
+	if classes := characterClasses(password); classes < p.RequireClasses {
+		violations = append(violations, FieldError{Field: "password", Message: "must contain at least " + strconv.Itoa(p.RequireClasses) + " of: lowercase letters, uppercase letters, digits, symbols"})
+	}
+	if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+		violations = append(violations, FieldError{Field: "password", Message: "is too common"})
+	}
+	if login != "" && strings.Contains(strings.ToLower(password), strings.ToLower(login)) {
+		violations = append(violations, FieldError{Field: "password", Message: "must not contain the login"})
+	}
+
+	return violations
+}
+
+func characterClasses(password string) (classes int) {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+
+	return classes
+}
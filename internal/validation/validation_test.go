@@ -0,0 +1,100 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLogin(t *testing.T) {
+	tests := []struct {
+		name           string
+		login          string
+		wantViolations int
+	}{
+		{
+			name:           "Positive_Valid",
+			login:          "john.doe-42",
+			wantViolations: 0,
+		},
+		{
+			name:           "Negative_TooShort",
+			login:          "jo",
+			wantViolations: 1,
+		},
+		{
+			name:           "Negative_Empty",
+			login:          "",
+			wantViolations: 1,
+		},
+		{
+			name:           "Negative_WrongCharset",
+			login:          "john doe",
+			wantViolations: 1,
+		},
+		{
+			name:           "Negative_TooLongAndWrongStart",
+			login:          "_" + strings.Repeat("a", 40),
+			wantViolations: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, ValidateLogin(tt.login), tt.wantViolations)
+		})
+	}
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, RequireClasses: 2}
+
+	tests := []struct {
+		name           string
+		password       string
+		login          string
+		wantViolations int
+	}{
+		{
+			name:           "Positive_Valid",
+			password:       "correct horse 7",
+			login:          "john",
+			wantViolations: 0,
+		},
+		{
+			name:           "Negative_Empty",
+			password:       "",
+			login:          "john",
+			wantViolations: 1,
+		},
+		{
+			name:           "Negative_ShortSingleClass",
+			password:       "abcdef",
+			login:          "john",
+			wantViolations: 2,
+		},
+		{
+			name:           "Negative_TooLongForBcrypt",
+			password:       strings.Repeat("aB", 40),
+			login:          "john",
+			wantViolations: 1,
+		},
+		{
+			name:           "Negative_Common",
+			password:       "Password123",
+			login:          "john",
+			wantViolations: 1,
+		},
+		{
+			name:           "Negative_ContainsLogin",
+			password:       "John2023!",
+			login:          "john",
+			wantViolations: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, policy.Validate(tt.password, tt.login), tt.wantViolations)
+		})
+	}
+}